@@ -18,6 +18,7 @@ require (
 	github.com/paysuper/paysuper-proto/go/recurringpb v0.0.0-20200131105822-66c79290d252
 	github.com/paysuper/paysuper-recurring-repository v1.0.128
 	github.com/paysuper/paysuper-tools v0.0.0-20200117101901-522574ce4d1c
+	github.com/prometheus/client_golang v1.2.1
 	github.com/streadway/amqp v0.0.0-20190827072141-edfb9018d271
 	github.com/stretchr/testify v1.4.0
 	go.uber.org/zap v1.13.0
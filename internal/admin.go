@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	adminAuthorizationPrefix = "Bearer "
+
+	adminRouteParkingReplay   = "/admin/parking/replay"
+	adminRouteAttempts        = "/admin/attempts"
+	adminRouteOrderResend     = "/admin/orders/resend"
+	adminRouteEndpointRestore = "/admin/endpoints/restore"
+	adminRouteWebhookTest     = "/admin/webhooks/test"
+
+	errorAdminUnauthorized     = "unauthorized"
+	errorAdminMethodNotAllowed = "method not allowed"
+	errorAdminBadRequest       = "bad request"
+	errorAdminAttemptsFilter   = "order_id or project_id is required"
+	errorAdminEndpointRequired = "project_id and url are required"
+)
+
+type adminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type parkingReplayRequest struct {
+	// Identifiers of orders to replay, all parked orders are replayed if empty
+	OrderIds []string `json:"order_ids"`
+	// Maximum count of replayed orders, unlimited if zero
+	Limit int `json:"limit"`
+}
+
+type parkingReplayResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+type orderResendRequest struct {
+	OrderId string `json:"order_id"`
+	// Event to send instead of the event of the current order status, optional
+	Event string `json:"event"`
+}
+
+type webhookTestRequest struct {
+	ProjectId string `json:"project_id"`
+	// Event of the test notification: webhook.ping or the event of the sample order, payment.success by default
+	Event string `json:"event"`
+}
+
+type endpointRestoreRequest struct {
+	ProjectId string `json:"project_id"`
+	// Notification url of the project exactly as it was retired
+	Url string `json:"url"`
+}
+
+type endpointRestoreResponse struct {
+	Restored bool `json:"restored"`
+}
+
+type attemptsResponse struct {
+	Items []*handler.DeliveryAttempt `json:"items"`
+}
+
+func (app *NotifierApplication) initAdmin() {
+	if app.cfg.AdminToken == "" {
+		app.log.Info("Admin api disabled because admin token is empty")
+		return
+	}
+
+	app.router.HandleFunc(adminRouteParkingReplay, app.adminAuth(http.MethodPost, app.parkingReplay))
+	app.router.HandleFunc(adminRouteAttempts, app.adminAuth(http.MethodGet, app.listAttempts))
+	app.router.HandleFunc(adminRouteOrderResend, app.adminAuth(http.MethodPost, app.orderResend))
+	app.router.HandleFunc(adminRouteEndpointRestore, app.adminAuth(http.MethodPost, app.endpointRestore))
+	app.router.HandleFunc(adminRouteWebhookTest, app.adminAuth(http.MethodPost, app.webhookTest))
+}
+
+func (app *NotifierApplication) adminAuth(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), adminAuthorizationPrefix)
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(app.cfg.AdminToken)) != 1 {
+			writeJson(w, http.StatusUnauthorized, &adminErrorResponse{Error: errorAdminUnauthorized})
+			return
+		}
+
+		if r.Method != method {
+			writeJson(w, http.StatusMethodNotAllowed, &adminErrorResponse{Error: errorAdminMethodNotAllowed})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func (app *NotifierApplication) parkingReplay(w http.ResponseWriter, r *http.Request) {
+	req := &parkingReplayRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminBadRequest})
+		return
+	}
+
+	replayed, err := app.parking.Replay(req.OrderIds, req.Limit, app.replayParkedOrder)
+
+	if err != nil {
+		app.log.Error("Replay of parked notifications failed", zap.Error(err), zap.Int("replayed", replayed))
+		writeJson(w, http.StatusInternalServerError, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+
+	app.log.Info("Parked notifications replayed", zap.Int("replayed", replayed), zap.Strings("order_ids", req.OrderIds))
+	writeJson(w, http.StatusOK, &parkingReplayResponse{Replayed: replayed})
+}
+
+// replayParkedOrder republishes the parked order to the notification topic with the retry count and the lifetime reset
+func (app *NotifierApplication) replayParkedOrder(order *billingpb.Order) error {
+	if err := handler.ResetNotificationStat(app.redis, order); err != nil {
+		return err
+	}
+
+	return app.notifyBroker.Publish(recurringpb.PayOneTopicNotifyPaymentName, order, handler.GetReplayHeaders())
+}
+
+// listAttempts returns the latest delivery attempts filtered by order_id or project_id query parameter
+func (app *NotifierApplication) listAttempts(w http.ResponseWriter, r *http.Request) {
+	var (
+		attempts []*handler.DeliveryAttempt
+		err      error
+		limit    int64
+	)
+
+	query := r.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminBadRequest})
+			return
+		}
+	}
+
+	if orderId := query.Get("order_id"); orderId != "" {
+		attempts, err = handler.GetOrderDeliveryAttempts(app.redis, orderId, limit)
+	} else if projectId := query.Get("project_id"); projectId != "" {
+		attempts, err = handler.GetProjectDeliveryAttempts(app.redis, projectId, limit)
+	} else {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminAttemptsFilter})
+		return
+	}
+
+	if err != nil {
+		app.log.Error("Get delivery attempts failed", zap.Error(err))
+		writeJson(w, http.StatusInternalServerError, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJson(w, http.StatusOK, &attemptsResponse{Items: attempts})
+}
+
+func (app *NotifierApplication) orderResend(w http.ResponseWriter, r *http.Request) {
+	req := &orderResendRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminBadRequest})
+		return
+	}
+
+	result, err := app.Resend(r.Context(), req.OrderId, req.Event)
+
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// webhookTest sends the test notification to the project endpoints without storing anything
+func (app *NotifierApplication) webhookTest(w http.ResponseWriter, r *http.Request) {
+	req := &webhookTestRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminBadRequest})
+		return
+	}
+
+	result, err := app.TestWebhook(r.Context(), req.ProjectId, req.Event)
+
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+// endpointRestore removes the retired mark of the project endpoint which responded 410 Gone
+func (app *NotifierApplication) endpointRestore(w http.ResponseWriter, r *http.Request) {
+	req := &endpointRestoreRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminBadRequest})
+		return
+	}
+
+	if req.ProjectId == "" || req.Url == "" {
+		writeJson(w, http.StatusBadRequest, &adminErrorResponse{Error: errorAdminEndpointRequired})
+		return
+	}
+
+	retired, err := handler.IsEndpointRetired(app.redis, req.ProjectId, req.Url)
+
+	if err == nil && retired {
+		err = handler.RestoreEndpoint(app.redis, req.ProjectId, req.Url)
+	}
+
+	if err != nil {
+		app.log.Error("Restore of retired endpoint failed", zap.Error(err))
+		writeJson(w, http.StatusInternalServerError, &adminErrorResponse{Error: err.Error()})
+		return
+	}
+
+	app.log.Info("Retired endpoint restored", zap.String("project_id", req.ProjectId), zap.String("url", req.Url))
+	writeJson(w, http.StatusOK, &endpointRestoreResponse{Restored: retired})
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", handler.MIMEApplicationJSON)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Error("Write http response failed", zap.Error(err))
+	}
+}
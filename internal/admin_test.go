@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/mock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	adminTestToken = "admin-token"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+	app     *NotifierApplication
+	parking *mock.ParkingMockOk
+}
+
+func Test_Admin(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
+
+func (suite *AdminTestSuite) SetupTest() {
+	cfg, err := config.NewConfig()
+	assert.NoError(suite.T(), err)
+
+	cfg.AdminToken = adminTestToken
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisHost,
+		Password: cfg.RedisPassword,
+	})
+
+	_, err = rdb.Ping().Result()
+	assert.NoError(suite.T(), err)
+
+	suite.parking = mock.NewParkingMockOk()
+
+	for _, id := range []string{"order-1", "order-2", "order-3"} {
+		err = suite.parking.Publish(&billingpb.Order{Id: id, Project: &billingpb.ProjectOrder{}}, amqp.Table{})
+		assert.NoError(suite.T(), err)
+	}
+
+	suite.app = &NotifierApplication{
+		cfg:          cfg,
+		log:          zap.NewNop(),
+		router:       http.NewServeMux(),
+		redis:        rdb,
+		notifyBroker: mock.NewBrokerMockOk(),
+		parking:      suite.parking,
+	}
+	suite.app.initAdmin()
+}
+
+func (suite *AdminTestSuite) TearDownTest() {
+	_ = suite.app.redis.FlushDB()
+	_ = suite.app.redis.Close()
+}
+
+func (suite *AdminTestSuite) request(method, token string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, adminRouteParkingReplay, bytes.NewReader(body))
+	req.Header.Set("Authorization", adminAuthorizationPrefix+token)
+
+	w := httptest.NewRecorder()
+	suite.app.router.ServeHTTP(w, req)
+
+	return w
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_Ok() {
+	w := suite.request(http.MethodPost, adminTestToken, []byte(`{"order_ids": ["order-1", "order-3"]}`))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	rsp := &parkingReplayResponse{}
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), rsp))
+	assert.Equal(suite.T(), 2, rsp.Replayed)
+	assert.Len(suite.T(), suite.parking.Parked, 1)
+	assert.Equal(suite.T(), "order-2", suite.parking.Parked[0].Id)
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_Limit() {
+	w := suite.request(http.MethodPost, adminTestToken, []byte(`{"limit": 2}`))
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	rsp := &parkingReplayResponse{}
+	assert.NoError(suite.T(), json.Unmarshal(w.Body.Bytes(), rsp))
+	assert.Equal(suite.T(), 2, rsp.Replayed)
+	assert.Len(suite.T(), suite.parking.Parked, 1)
+	assert.Equal(suite.T(), "order-3", suite.parking.Parked[0].Id)
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_Unauthorized() {
+	w := suite.request(http.MethodPost, "wrong-token", []byte(`{}`))
+	assert.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	assert.Len(suite.T(), suite.parking.Parked, 3)
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_MethodNotAllowed() {
+	w := suite.request(http.MethodGet, adminTestToken, nil)
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, w.Code)
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_BadRequest() {
+	w := suite.request(http.MethodPost, adminTestToken, []byte(`{"limit": "all"}`))
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Len(suite.T(), suite.parking.Parked, 3)
+}
+
+func (suite *AdminTestSuite) TestAdmin_ParkingReplay_Failed() {
+	_ = suite.app.redis.Close()
+
+	w := suite.request(http.MethodPost, adminTestToken, []byte(`{}`))
+	assert.Equal(suite.T(), http.StatusInternalServerError, w.Code)
+	assert.Len(suite.T(), suite.parking.Parked, 3)
+}
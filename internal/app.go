@@ -14,11 +14,15 @@ import (
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
+	"github.com/paysuper/paysuper-webhook-notifier/pkg/notifierpb"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 	rabbitmq "gopkg.in/ProtocolONE/rabbitmq.v1/pkg"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"time"
@@ -28,23 +32,33 @@ const (
 	serviceName   = "p1paynotifier"
 	loggerName    = "PAYSUPER_WEBHOOK_NOTIFIER"
 	mutexNameMask = "%s-%s"
+	// Time of the notification processing besides the http requests: redis, billing and broker calls
+	mutexTimeoutReserve = 30 * time.Second
 )
 
 type NotifierApplication struct {
-	cfg  *config.Config
-	repo billingpb.BillingService
+	cfg     *config.Config
+	repo    billingpb.BillingService
+	service micro.Service
 
 	centrifugoPaymentForm handler.CentrifugoInterface
 	centrifugoDashboard   handler.CentrifugoInterface
 
 	httpServer *http.Server
 	router     *http.ServeMux
+	// public listener of the payload fetch urls, kept apart from the metrics and admin routes
+	fetchServer *http.Server
+	fetchRouter *http.ServeMux
+
+	notifierHttpClients *handler.HttpClients
 
 	log                      *zap.Logger
 	broker                   rabbitmq.BrokerInterface
-	retryBroker              rabbitmq.BrokerInterface
+	retryBrokers             handler.RetryBrokers
 	taxjarTransactionsBroker rabbitmq.BrokerInterface
 	taxjarRefundsBroker      rabbitmq.BrokerInterface
+	notifyBroker             rabbitmq.BrokerInterface
+	parking                  handler.ParkingInterface
 	redis                    *redis.Client
 }
 
@@ -74,8 +88,6 @@ func (app *NotifierApplication) Init() {
 	app.initRedis()
 	app.initBroker()
 
-	var service micro.Service
-
 	options := []micro.Option{
 		micro.Name(serviceName),
 		micro.Version(recurringpb.PayOneMicroserviceVersion),
@@ -92,15 +104,25 @@ func (app *NotifierApplication) Init() {
 
 	app.log.Info("Initialize micro service")
 
-	service = micro.NewService(options...)
-	service.Init()
+	app.service = micro.NewService(options...)
+	app.service.Init()
 
-	app.repo = billingpb.NewBillingService(billingpb.ServiceName, service.Client())
+	app.repo = billingpb.NewBillingService(billingpb.ServiceName, app.service.Client())
 	app.centrifugoPaymentForm = handler.NewCentrifugo(app.cfg.CentrifugoPaymentForm, NewCentrifugoHttpClient())
 	app.centrifugoDashboard = handler.NewCentrifugo(app.cfg.CentrifugoDashboard, NewCentrifugoHttpClient())
+	app.initNotifierHttpClient()
 
 	app.router = http.NewServeMux()
 	app.initHealth()
+	app.initMetrics()
+	app.initAdmin()
+	app.initFetch()
+
+	err := notifierpb.RegisterNotifierServiceHandler(app.service.Server(), &NotifierService{app: app})
+
+	if err != nil {
+		app.log.Fatal("Micro service handler register failed", zap.Error(err))
+	}
 }
 
 func (app *NotifierApplication) initRedis() {
@@ -114,6 +136,16 @@ func (app *NotifierApplication) initRedis() {
 	}
 }
 
+func (app *NotifierApplication) initNotifierHttpClient() {
+	guard, err := handler.NewAddressGuard(app.cfg.OutboundAllowlist)
+
+	if err != nil {
+		app.log.Fatal("Outbound allowlist parsing failed", zap.Error(err), zap.Strings("allowlist", app.cfg.OutboundAllowlist))
+	}
+
+	app.notifierHttpClients = handler.NewHttpClients(guard, app.cfg)
+}
+
 func (app *NotifierApplication) initLogger() {
 	logger, err := zap.NewProduction()
 
@@ -128,6 +160,10 @@ func (app *NotifierApplication) initLogger() {
 func (app *NotifierApplication) initConfig() {
 	cfg, err := config.NewConfig()
 
+	if err == nil {
+		err = handler.ParseTemplates(cfg)
+	}
+
 	if err != nil {
 		app.log.Fatal("Config init failed", zap.Error(err))
 	}
@@ -146,22 +182,39 @@ func (app *NotifierApplication) initBroker() {
 		)
 	}
 
-	retryBroker, err := rabbitmq.NewBroker(app.cfg.BrokerAddress)
+	delays := app.cfg.RetryDelays
 
-	if err != nil {
-		app.log.Fatal(
-			"Creating RabbitMq retry broker failed",
-			zap.Error(err),
-			zap.String("amqp_url", app.cfg.BrokerAddress),
-		)
+	if len(delays) == 0 {
+		delays = []int32{handler.RetryDlxTimeout}
 	}
 
-	retryBroker.(*rabbitmq.Broker).Opts.QueueOpts.Args = amqp.Table{
-		"x-dead-letter-exchange":    recurringpb.PayOneTopicNotifyPaymentName,
-		"x-message-ttl":             int32(handler.RetryDlxTimeout * 1000),
-		"x-dead-letter-routing-key": "*",
+	retryBrokers := make(handler.RetryBrokers, len(delays))
+
+	for _, delay := range delays {
+		if delay <= 0 || int64(delay)*1000 > math.MaxUint32 {
+			app.log.Fatal("Invalid retry delay", zap.Int32("delay", delay))
+		}
+
+		retryBroker, err := rabbitmq.NewBroker(app.cfg.BrokerAddress)
+
+		if err != nil {
+			app.log.Fatal(
+				"Creating RabbitMq retry broker failed",
+				zap.Error(err),
+				zap.String("amqp_url", app.cfg.BrokerAddress),
+				zap.Int32("delay", delay),
+			)
+		}
+
+		retryBroker.(*rabbitmq.Broker).Opts.QueueOpts.Args = amqp.Table{
+			"x-dead-letter-exchange":    recurringpb.PayOneTopicNotifyPaymentName,
+			"x-message-ttl":             int64(delay) * 1000,
+			"x-dead-letter-routing-key": "*",
+		}
+		retryBroker.SetExchangeName(handler.GetRetryExchangeName(delay))
+
+		retryBrokers[delay] = retryBroker
 	}
-	retryBroker.SetExchangeName(handler.RetryExchangeName)
 
 	err = broker.RegisterSubscriber(recurringpb.PayOneTopicNotifyPaymentName, app.Process)
 
@@ -189,10 +242,30 @@ func (app *NotifierApplication) initBroker() {
 	}
 	taxjarRefundsBroker.SetExchangeName(recurringpb.TaxjarRefundsTopicName)
 
+	notifyBroker, err := rabbitmq.NewBroker(app.cfg.BrokerAddress)
+	if err != nil {
+		app.log.Fatal(
+			"Creating RabbitMq notification broker failed",
+			zap.Error(err),
+			zap.String("amqp_url", app.cfg.BrokerAddress),
+		)
+	}
+
+	parking, err := handler.NewParking(app.cfg.BrokerAddress)
+	if err != nil {
+		app.log.Fatal(
+			"Creating RabbitMq parking queue failed",
+			zap.Error(err),
+			zap.String("amqp_url", app.cfg.BrokerAddress),
+		)
+	}
+
 	app.broker = broker
-	app.retryBroker = retryBroker
+	app.retryBrokers = retryBrokers
 	app.taxjarTransactionsBroker = taxjarTransactionsBroker
 	app.taxjarRefundsBroker = taxjarRefundsBroker
+	app.notifyBroker = notifyBroker
+	app.parking = parking
 }
 
 func (app *NotifierApplication) initHealth() {
@@ -221,6 +294,10 @@ func (app *NotifierApplication) initHealth() {
 	app.router.HandleFunc("/health", handlers.NewJSONHandlerFunc(h, nil))
 }
 
+func (app *NotifierApplication) initMetrics() {
+	app.router.Handle("/metrics", promhttp.Handler())
+}
+
 func (app *NotifierApplication) Run() {
 	app.httpServer = &http.Server{
 		Addr:    ":" + app.cfg.MetricsPort,
@@ -233,7 +310,24 @@ func (app *NotifierApplication) Run() {
 		}
 	}()
 
+	app.fetchServer = &http.Server{
+		Addr:    ":" + app.cfg.PayloadFetchPort,
+		Handler: app.fetchRouter,
+	}
+
+	go func() {
+		if err := app.fetchServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			app.log.Fatal("Payload fetch server starting failed", zap.Error(err))
+		}
+	}()
+
 	app.log.Info("Http server started...")
+
+	if err := app.service.Server().Start(); err != nil {
+		app.log.Fatal("Micro service server starting failed", zap.Error(err))
+	}
+
+	app.log.Info("Micro service server started...")
 	app.log.Info("Notifier started...")
 
 	if err := app.broker.Subscribe(nil); err != nil {
@@ -250,6 +344,15 @@ func (app *NotifierApplication) Stop() {
 	}
 	app.log.Info("Http server stopped")
 
+	if err := app.fetchServer.Shutdown(ctx); err != nil {
+		app.log.Fatal("Payload fetch server shutdown failed", zap.Error(err))
+	}
+	app.log.Info("Payload fetch server stopped")
+
+	if err := app.service.Server().Stop(); err != nil {
+		app.log.Error("Micro service server stop failed", zap.Error(err))
+	}
+
 	func() {
 		if err := app.log.Sync(); err != nil {
 			app.log.Fatal("Logger sync failed", zap.Error(err))
@@ -264,12 +367,14 @@ func (app *NotifierApplication) Process(o *billingpb.Order, d amqp.Delivery) err
 	handlerName := o.Project.GetCallbackProtocol()
 	mName := fmt.Sprintf(mutexNameMask, handlerName, id)
 
-	mutex, err := lock.Obtain(app.redis, mName, nil)
+	mutex, err := lock.Obtain(app.redis, mName, &lock.Options{LockTimeout: app.getLockTimeout(o)})
 
 	if err != nil {
+		metrics.LockErrorsTotal.Inc()
 		app.log.Error(err.Error())
 		return err
 	} else if mutex == nil {
+		metrics.LockContentionTotal.Inc()
 		return nil
 	}
 
@@ -279,18 +384,12 @@ func (app *NotifierApplication) Process(o *billingpb.Order, d amqp.Delivery) err
 		}
 	}()
 
-	h := handler.NewHandler(
-		o,
-		app.repo,
-		app.retryBroker,
-		app.taxjarTransactionsBroker,
-		app.taxjarRefundsBroker,
-		app.redis,
-		d,
-		app.cfg,
-		app.centrifugoPaymentForm,
-		app.centrifugoDashboard,
-	)
+	h := app.newHandler(o, d)
+
+	// notification came from the delay queue earlier than its next try is returned to the delay queues
+	if postponed, err := h.Postpone(); postponed || err != nil {
+		return err
+	}
 
 	n, err := h.GetNotifier()
 
@@ -300,7 +399,11 @@ func (app *NotifierApplication) Process(o *billingpb.Order, d amqp.Delivery) err
 
 	err = n.Notify()
 
-	if h.RetryCount == 0 {
+	metrics.NotificationsTotal.WithLabelValues(handlerName, h.GetResult(err)).Inc()
+	metrics.NotificationRetryCount.WithLabelValues(handlerName).Observe(float64(h.RetryCount))
+
+	// the user is notified once, not on every retry or deferral of the notification
+	if h.IsFirstProcessing() {
 		err := h.SendToUserCentrifugo(o)
 
 		if err != nil {
@@ -311,6 +414,38 @@ func (app *NotifierApplication) Process(o *billingpb.Order, d amqp.Delivery) err
 	return err
 }
 
+// getLockTimeout returns lifetime of the order notification lock. The lock must outlive the requests to every
+// endpoint of the project sent one after another, otherwise the redelivered message is processed concurrently.
+func (app *NotifierApplication) getLockTimeout(o *billingpb.Order) time.Duration {
+	id := o.GetProject().GetId()
+	endpoints := len(app.cfg.GetProject(id).Endpoints)
+
+	if endpoints == 0 {
+		endpoints = 1
+	}
+
+	timeout := time.Duration(app.cfg.GetHttpClient(id).Timeout) * time.Second
+
+	return time.Duration(endpoints)*timeout + mutexTimeoutReserve
+}
+
+func (app *NotifierApplication) newHandler(o *billingpb.Order, d amqp.Delivery) *handler.Handler {
+	return handler.NewHandler(
+		o,
+		app.repo,
+		app.retryBrokers,
+		app.taxjarTransactionsBroker,
+		app.taxjarRefundsBroker,
+		app.parking,
+		app.notifierHttpClients.Get(o.GetProject().GetId()),
+		app.redis,
+		d,
+		app.cfg,
+		app.centrifugoPaymentForm,
+		app.centrifugoDashboard,
+	)
+}
+
 func (c *appHealthCheck) Status() (interface{}, error) {
 	if _, err := c.redis.Ping().Result(); err != nil {
 		return "fail", err
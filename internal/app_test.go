@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestApp_getLockTimeout(t *testing.T) {
+	cfg, err := config.NewConfig()
+	assert.NoError(t, err)
+
+	cfg.HttpTimeout = 30
+	app := &NotifierApplication{cfg: cfg}
+	o := &billingpb.Order{Project: &billingpb.ProjectOrder{Id: "254e3736-000f-5000-8000-178d1d80bf70"}}
+
+	assert.Equal(t, 30*time.Second+mutexTimeoutReserve, app.getLockTimeout(o))
+
+	// requests to the endpoints are sent one after another, so the lock outlives all of them
+	cfg.Projects = config.Projects{
+		o.Project.Id: {
+			Endpoints: []*config.Endpoint{{Url: "http://localhost/1"}, {Url: "http://localhost/2"}},
+			Http:      &config.HttpClient{Timeout: 10},
+		},
+	}
+	assert.Equal(t, 20*time.Second+mutexTimeoutReserve, app.getLockTimeout(o))
+}
@@ -1,12 +1,226 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"strings"
+	"text/template"
+)
+
+const (
+	// Notification is delivered
+	ResponseResultSuccess = "success"
+	// Notification is permanently rejected by the project, the order is marked as rejected
+	ResponseResultReject = "reject"
+	// Notification delivery failed temporary and is retried
+	ResponseResultRetry = "retry"
+
+	// Notification of the default protocol contains the projected order object
+	PayloadModeFull = "full"
+	// Notification of the default protocol contains only identifiers and the signed url to fetch the order
+	PayloadModeThin = "thin"
+
+	// Payload shape of the default protocol before the api versioning
+	PayloadVersionV1 = "v1"
+	// Adds receipt and cancellation fields of the order to the payload
+	PayloadVersionV2 = "v2"
+	// Adds the expanded event catalogue and the decline details
+	PayloadVersionV3 = "v3"
+
+	// CloudEvents attributes are sent in ce-* headers and the body contains only the event data
+	CloudEventsModeBinary = "binary"
+	// Whole CloudEvent is sent in the body with the application/cloudevents+json content type
+	CloudEventsModeStructured = "structured"
+
+	// Signature of the default protocol calculated as sha256(body + secret key) and sent in Authorization header
+	SignatureSchemeLegacy = "legacy"
+	// Signature of the default protocol calculated as HMAC-SHA256 of "timestamp.body" with the project secret key
+	SignatureSchemeHmacSha256 = "hmac-sha256"
+
+	// Hash algorithms of the template protocol signature
+	TemplateSignatureMd5        = "md5"
+	TemplateSignatureSha1       = "sha1"
+	TemplateSignatureSha256     = "sha256"
+	TemplateSignatureSha512     = "sha512"
+	TemplateSignatureHmacSha1   = "hmac-sha1"
+	TemplateSignatureHmacSha256 = "hmac-sha256"
+	TemplateSignatureHmacSha512 = "hmac-sha512"
+
+	// Encodings of the template protocol signature
+	TemplateSignatureEncodingHex    = "hex"
+	TemplateSignatureEncodingBase64 = "base64"
+
+	// Names of the parsed templates of the template protocol
+	TemplateNameBody              = "body"
+	TemplateNameSignaturePayload  = "signature.payload"
+	TemplateNameSignatureKey      = "signature.key"
+	templateNameHeaderPrefix      = "header."
+	templateOptionMissingKeyError = "missingkey=error"
+
+	errorResponseResultInvalid  = "invalid result \"%s\" of response rule of project %s"
+	errorSignatureSchemeInvalid = "invalid signature scheme \"%s\" of project %s"
+	errorSignatureSchemeGlobal  = "invalid signature scheme \"%s\""
+	errorPayloadModeInvalid     = "invalid payload mode \"%s\" of project %s"
+	errorPayloadVersionInvalid  = "invalid payload version \"%s\" of project %s"
+	errorEndpointUrlEmpty       = "empty url of endpoint of project %s"
+	errorCloudEventsModeInvalid = "invalid cloudevents mode \"%s\" of project %s"
+	errorTemplateBodyEmpty      = "empty body of template of project %s"
+	errorTemplateAlgorithm      = "invalid signature algorithm \"%s\" of template of project %s"
+	errorTemplateEncoding       = "invalid signature encoding \"%s\" of template of project %s"
+	errorTemplateInvalid        = "invalid template \"%s\" of project %s: %s"
+	errorTemplateNotParsed      = "template isn't parsed"
+	errorPayloadFetchUrlEmpty   = "empty payload fetch url, project %s uses thin payload mode"
+	errorHttpTimeoutInvalid     = "invalid http timeout %d, it must be positive"
+	errorBackoffMaxInvalid      = "invalid retry backoff max %d, it must be positive"
+
+	eventNameWildcard = "*"
+)
 
 type Centrifugo struct {
 	ApiSecret string `required:"true"`
 	URL       string `default:"http://127.0.0.1:8000"`
 }
 
+// RetryPolicy describes how failed notifications are retried. Zero values mean the service default is used.
+type RetryPolicy struct {
+	// Maximum count of delivery retries
+	MaxCount int32 `json:"max_count"`
+	// Maximum time in seconds from the first retry after which the notification is not retried anymore
+	MaxAge int64 `json:"max_age"`
+	// Delay in seconds before the first retry
+	BackoffBase int32 `json:"backoff_base"`
+	// Multiplier of the delay for every next retry
+	BackoffFactor float64 `json:"backoff_factor"`
+	// Maximum delay in seconds between retries
+	BackoffMax int32 `json:"backoff_max"`
+	// Random deviation of the delay as a fraction of its value
+	BackoffJitter float64 `json:"backoff_jitter"`
+}
+
+// HttpClient describes the outbound http client for requests to projects. Zero values mean the service default
+// is used. Timeouts are in seconds.
+type HttpClient struct {
+	// Timeout of the connection establishing
+	ConnectTimeout int64 `json:"connect_timeout"`
+	// Timeout of the TLS handshake
+	TlsHandshakeTimeout int64 `json:"tls_handshake_timeout"`
+	// Overall timeout of the request including redirects and reading of the response body
+	Timeout int64 `json:"timeout"`
+	// Time an idle keep-alive connection remains in the pool
+	IdleConnTimeout int64 `json:"idle_conn_timeout"`
+	// Maximum count of idle keep-alive connections across all hosts
+	MaxIdleConns int `json:"max_idle_conns"`
+	// Maximum count of idle keep-alive connections per host
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+	// Maximum count of connections per host including the active ones
+	MaxConnsPerHost int `json:"max_conns_per_host"`
+	// Maximum count of followed redirects, negative value disables redirects
+	MaxRedirects int `json:"max_redirects"`
+}
+
+// RateLimit describes the token bucket of outbound requests
+type RateLimit struct {
+	// Count of requests per second, the limit is disabled if zero
+	Rate float64 `json:"rate"`
+	// Maximum count of requests sent in a burst
+	Burst int64 `json:"burst"`
+}
+
+// TemplateNameHeader returns name of the parsed template of the request header
+func TemplateNameHeader(name string) string {
+	return templateNameHeaderPrefix + name
+}
+
+// Template describes the request of the template callback protocol. Body, headers and the signature parts are
+// text/template templates executed over the notification, they are parsed once by Projects.ParseTemplates with
+// the helper functions of the package executing them.
+type Template struct {
+	// Template of the request body
+	Body string `json:"body"`
+	// Content type of the request, application/json if empty
+	ContentType string `json:"content_type"`
+	// Templates of the request headers keyed by the header name, the signature is available to them
+	Headers map[string]string `json:"headers"`
+	// Signature of the request, the request isn't signed if empty
+	Signature *TemplateSignature `json:"signature"`
+
+	parsed *template.Template
+}
+
+// TemplateSignature is the recipe of the template protocol signature
+type TemplateSignature struct {
+	// Hash algorithm: md5, sha1, sha256, sha512, hmac-sha1, hmac-sha256 or hmac-sha512
+	Algorithm string `json:"algorithm"`
+	// Template of the key, the project secret key if empty. It is the hmac key or the suffix of the signed
+	// string of plain hash algorithms
+	Key string `json:"key"`
+	// Template of the signed string, the request body if empty
+	Payload string `json:"payload"`
+	// Encoding of the signature: hex or base64, hex if empty
+	Encoding string `json:"encoding"`
+}
+
+// ResponseRule maps the project response to the result of the notification delivery.
+// The rule matches the response if all of its non-empty conditions are met.
+type ResponseRule struct {
+	// Http status codes of the response
+	Status []int `json:"status"`
+	// Substring which the response body must contain
+	BodyContains string `json:"body_contains"`
+	// Fields of JSON object in the response body which must have specified values, for example {"status": "ok"}
+	BodyJson map[string]interface{} `json:"body_json"`
+	// Result of the delivery: success, reject or retry
+	Result string `json:"result"`
+}
+
+// Endpoint is the notification url of the project with its own event filter and secret key.
+type Endpoint struct {
+	Url string `json:"url"`
+	// Names of events delivered to the endpoint, for example "payment.success" or "payment.*".
+	// All events are delivered if empty.
+	Events []string `json:"events"`
+	// Secret key of the notification signatures, the project secret key is used if empty
+	SecretKey string `json:"secret_key"`
+}
+
+// Project contains the notification settings of a single project which override the service defaults.
+type Project struct {
+	SignatureScheme string `json:"signature_scheme"`
+	// Retry policy of the project in live mode. Also used in test mode if RetryTest is empty
+	Retry *RetryPolicy `json:"retry"`
+	// Retry policy of the project in test mode
+	RetryTest *RetryPolicy `json:"retry_test"`
+	// Settings of the http client for requests to the project
+	Http *HttpClient `json:"http"`
+	// Response classification rules checked in order before the rules of the callback protocol
+	Responses []*ResponseRule `json:"responses"`
+	// Rate limit of requests to the project
+	RateLimit *RateLimit `json:"rate_limit"`
+	// Order fields sent to the project by the default protocol in addition to the service defaults
+	PayloadFields []string `json:"payload_fields"`
+	// Payload mode of the default protocol: full order object or thin event with the url to fetch the order
+	PayloadMode string `json:"payload_mode"`
+	// Api version of the default protocol payload the project is pinned to
+	PayloadVersion string `json:"payload_version"`
+	// Lifetime of the notification event in seconds, the event is not delivered after it
+	NotificationTtl int64 `json:"notification_ttl"`
+	// Notification urls of the default protocol used instead of the project url if not empty
+	Endpoints []*Endpoint `json:"endpoints"`
+	// Names of events the project subscribed to, for example "payment.success" or "payment.*".
+	// All events are sent if empty.
+	Events []string `json:"events"`
+	// Content mode of the cloudevents protocol: binary or structured
+	CloudEventsMode string `json:"cloudevents_mode"`
+	// Request of the template protocol
+	Template *Template `json:"template"`
+}
+
+// Projects is the set of per-project settings keyed by the project identifier.
+// The value is decoded from a JSON object, for example: {"<project_id>": {"signature_scheme": "legacy"}}
+type Projects map[string]*Project
+
 type Config struct {
 	BrokerAddress string `envconfig:"BROKER_ADDRESS" default:"amqp://127.0.0.1:5672"`
 	MetricsPort   string `envconfig:"METRICS_PORT" required:"false" default:"8087"`
@@ -17,11 +231,466 @@ type Config struct {
 	CentrifugoDashboard    *Centrifugo `envconfig:"CENTRIFUGO_DASHBOARD"`
 	CentrifugoUserChannel  string      `envconfig:"CENTRIFUGO_USER_CHANNEL" default:"paysuper:order#%s"`
 	CentrifugoAdminChannel string      `envconfig:"CENTRIFUGO_ADMIN_CHANNEL" default:"paysuper:admin"`
+
+	RetryDelays        []int32 `envconfig:"RETRY_DELAYS" default:"5,30,120,600,1800,3600"`
+	RetryMaxCount      int32   `envconfig:"RETRY_MAX_COUNT" default:"288"`
+	RetryMaxAge        int64   `envconfig:"RETRY_MAX_AGE" default:"172800"`
+	RetryTestMaxCount  int32   `envconfig:"RETRY_TEST_MAX_COUNT" default:"5"`
+	RetryTestMaxAge    int64   `envconfig:"RETRY_TEST_MAX_AGE" default:"3600"`
+	RetryBackoffBase   int32   `envconfig:"RETRY_BACKOFF_BASE" default:"5"`
+	RetryBackoffFactor float64 `envconfig:"RETRY_BACKOFF_FACTOR" default:"2"`
+	RetryBackoffMax    int32   `envconfig:"RETRY_BACKOFF_MAX" default:"3600"`
+	RetryBackoffJitter float64 `envconfig:"RETRY_BACKOFF_JITTER" default:"0.2"`
+
+	AdminToken string `envconfig:"ADMIN_TOKEN" default:""`
+
+	OutboundAllowlist []string `envconfig:"OUTBOUND_ALLOWLIST"`
+
+	AttemptsRetention       int64 `envconfig:"ATTEMPTS_RETENTION" default:"604800"`
+	AttemptsOrderMaxCount   int64 `envconfig:"ATTEMPTS_ORDER_MAX_COUNT" default:"100"`
+	AttemptsProjectMaxCount int64 `envconfig:"ATTEMPTS_PROJECT_MAX_COUNT" default:"1000"`
+	AttemptsBodyMaxLength   int   `envconfig:"ATTEMPTS_BODY_MAX_LENGTH" default:"4096"`
+
+	BreakerFailureThreshold int64 `envconfig:"BREAKER_FAILURE_THRESHOLD" default:"5"`
+	BreakerFailureWindow    int64 `envconfig:"BREAKER_FAILURE_WINDOW" default:"60"`
+	BreakerOpenTimeout      int64 `envconfig:"BREAKER_OPEN_TIMEOUT" default:"60"`
+
+	HttpConnectTimeout      int64 `envconfig:"HTTP_CONNECT_TIMEOUT" default:"5"`
+	HttpTlsHandshakeTimeout int64 `envconfig:"HTTP_TLS_HANDSHAKE_TIMEOUT" default:"5"`
+	HttpTimeout             int64 `envconfig:"HTTP_TIMEOUT" default:"30"`
+	HttpIdleConnTimeout     int64 `envconfig:"HTTP_IDLE_CONN_TIMEOUT" default:"90"`
+	HttpMaxIdleConns        int   `envconfig:"HTTP_MAX_IDLE_CONNS" default:"100"`
+	HttpMaxIdleConnsPerHost int   `envconfig:"HTTP_MAX_IDLE_CONNS_PER_HOST" default:"10"`
+	HttpMaxConnsPerHost     int   `envconfig:"HTTP_MAX_CONNS_PER_HOST" default:"20"`
+	HttpMaxRedirects        int   `envconfig:"HTTP_MAX_REDIRECTS" default:"10"`
+
+	RateLimitGlobal       float64 `envconfig:"RATE_LIMIT_GLOBAL" default:"0"`
+	RateLimitGlobalBurst  int64   `envconfig:"RATE_LIMIT_GLOBAL_BURST" default:"0"`
+	RateLimitProject      float64 `envconfig:"RATE_LIMIT_PROJECT" default:"10"`
+	RateLimitProjectBurst int64   `envconfig:"RATE_LIMIT_PROJECT_BURST" default:"20"`
+	RateLimitHost         float64 `envconfig:"RATE_LIMIT_HOST" default:"20"`
+	RateLimitHostBurst    int64   `envconfig:"RATE_LIMIT_HOST_BURST" default:"40"`
+
+	PayloadFields    []string `envconfig:"PAYLOAD_FIELDS"`
+	PayloadFetchUrl  string   `envconfig:"PAYLOAD_FETCH_URL"`
+	PayloadFetchPort string   `envconfig:"PAYLOAD_FETCH_PORT" default:"8088"`
+	PayloadFetchTtl  int64    `envconfig:"PAYLOAD_FETCH_TTL" default:"3600"`
+	PayloadVersion   string   `envconfig:"PAYLOAD_VERSION" default:"v1"`
+
+	NotificationTtl int64 `envconfig:"NOTIFICATION_TTL" default:"259200"`
+
+	SignatureScheme string   `envconfig:"SIGNATURE_SCHEME" default:"legacy"`
+	Projects        Projects `envconfig:"PROJECTS_SETTINGS"`
 }
 
 func NewConfig() (*Config, error) {
 	cfg := &Config{}
 	err := envconfig.Process("", cfg)
 
+	if err == nil && cfg.SignatureScheme != "" && !isSignatureScheme(cfg.SignatureScheme) {
+		err = fmt.Errorf(errorSignatureSchemeGlobal, cfg.SignatureScheme)
+	}
+
+	if err == nil && cfg.RetryBackoffMax <= 0 {
+		err = fmt.Errorf(errorBackoffMaxInvalid, cfg.RetryBackoffMax)
+	}
+
+	// the timeout bounds the notification lock, so requests without the timeout aren't allowed
+	if err == nil && cfg.HttpTimeout <= 0 {
+		err = fmt.Errorf(errorHttpTimeoutInvalid, cfg.HttpTimeout)
+	}
+
+	// thin notifications are useless if the merchant can't fetch the order by the url
+	if err == nil && cfg.PayloadFetchUrl == "" {
+		for id, p := range cfg.Projects {
+			if p != nil && p.PayloadMode == PayloadModeThin {
+				err = fmt.Errorf(errorPayloadFetchUrlEmpty, id)
+				break
+			}
+		}
+	}
+
 	return cfg, err
 }
+
+// GetProject returns settings of the project with specified identifier.
+// Empty settings are returned if the project has no overrides.
+func (c *Config) GetProject(id string) *Project {
+	if c == nil || c.Projects == nil {
+		return &Project{}
+	}
+
+	p, ok := c.Projects[id]
+
+	if !ok || p == nil {
+		return &Project{}
+	}
+
+	return p
+}
+
+// GetRetryPolicy returns retry policy of the project with specified identifier in live or test mode.
+// Project overrides take precedence over the service defaults.
+func (c *Config) GetRetryPolicy(id string, live bool) *RetryPolicy {
+	policy := &RetryPolicy{
+		MaxCount:      c.RetryMaxCount,
+		MaxAge:        c.RetryMaxAge,
+		BackoffBase:   c.RetryBackoffBase,
+		BackoffFactor: c.RetryBackoffFactor,
+		BackoffMax:    c.RetryBackoffMax,
+		BackoffJitter: c.RetryBackoffJitter,
+	}
+
+	p := c.GetProject(id)
+	override := p.Retry
+
+	if !live {
+		if c.RetryTestMaxCount > 0 {
+			policy.MaxCount = c.RetryTestMaxCount
+		}
+
+		if c.RetryTestMaxAge > 0 {
+			policy.MaxAge = c.RetryTestMaxAge
+		}
+
+		if p.RetryTest != nil {
+			override = p.RetryTest
+		}
+	}
+
+	policy.merge(override)
+
+	return policy
+}
+
+// GetHttpClient returns settings of the http client for requests to the project with specified identifier.
+// Project overrides take precedence over the service defaults.
+func (c *Config) GetHttpClient(id string) *HttpClient {
+	settings := &HttpClient{
+		ConnectTimeout:      c.HttpConnectTimeout,
+		TlsHandshakeTimeout: c.HttpTlsHandshakeTimeout,
+		Timeout:             c.HttpTimeout,
+		IdleConnTimeout:     c.HttpIdleConnTimeout,
+		MaxIdleConns:        c.HttpMaxIdleConns,
+		MaxIdleConnsPerHost: c.HttpMaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.HttpMaxConnsPerHost,
+		MaxRedirects:        c.HttpMaxRedirects,
+	}
+
+	settings.merge(c.GetProject(id).Http)
+
+	return settings
+}
+
+// GetGlobalRateLimit returns rate limit of all outbound requests
+func (c *Config) GetGlobalRateLimit() *RateLimit {
+	return &RateLimit{Rate: c.RateLimitGlobal, Burst: c.RateLimitGlobalBurst}
+}
+
+// GetProjectRateLimit returns rate limit of requests to the project with specified identifier.
+// Project override takes precedence over the service default.
+func (c *Config) GetProjectRateLimit(id string) *RateLimit {
+	if p := c.GetProject(id); p.RateLimit != nil && p.RateLimit.Rate > 0 {
+		return p.RateLimit
+	}
+
+	return &RateLimit{Rate: c.RateLimitProject, Burst: c.RateLimitProjectBurst}
+}
+
+// GetHostRateLimit returns rate limit of requests to a single destination host
+func (c *Config) GetHostRateLimit() *RateLimit {
+	return &RateLimit{Rate: c.RateLimitHost, Burst: c.RateLimitHostBurst}
+}
+
+// GetPayloadVersion returns api version of the default protocol payload of the project with specified identifier.
+// Pinned project version takes precedence over the service default.
+func (c *Config) GetPayloadVersion(id string) string {
+	if v := c.GetProject(id).PayloadVersion; v != "" {
+		return v
+	}
+
+	if c != nil && c.PayloadVersion != "" {
+		return c.PayloadVersion
+	}
+
+	return PayloadVersionV1
+}
+
+// GetNotificationTtl returns lifetime in seconds of notification events of the project with specified identifier.
+// Project override takes precedence over the service default, zero means events never expire.
+func (c *Config) GetNotificationTtl(id string) int64 {
+	if ttl := c.GetProject(id).NotificationTtl; ttl > 0 {
+		return ttl
+	}
+
+	if c == nil {
+		return 0
+	}
+
+	return c.NotificationTtl
+}
+
+// GetCloudEventsMode returns content mode of the cloudevents protocol of the project with specified identifier,
+// binary by default
+func (c *Config) GetCloudEventsMode(id string) string {
+	if mode := c.GetProject(id).CloudEventsMode; mode != "" {
+		return mode
+	}
+
+	return CloudEventsModeBinary
+}
+
+// Accepts checks that the event is delivered to the endpoint
+func (e *Endpoint) Accepts(event string) bool {
+	return matchEvent(e.Events, event)
+}
+
+// IsSubscribed checks that the project subscribed to the event
+func (p *Project) IsSubscribed(event string) bool {
+	return matchEvent(p.Events, event)
+}
+
+// matchEvent checks that the event matches any of the filters, all events match the empty filters.
+// Filter ending with "*" matches events by prefix.
+func matchEvent(filters []string, event string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+	for _, v := range filters {
+		if v == event || v == eventNameWildcard {
+			return true
+		}
+
+		if strings.HasSuffix(v, eventNameWildcard) && strings.HasPrefix(event, strings.TrimSuffix(v, eventNameWildcard)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RetryPolicy) merge(o *RetryPolicy) {
+	if o == nil {
+		return
+	}
+
+	if o.MaxCount > 0 {
+		p.MaxCount = o.MaxCount
+	}
+
+	if o.MaxAge > 0 {
+		p.MaxAge = o.MaxAge
+	}
+
+	if o.BackoffBase > 0 {
+		p.BackoffBase = o.BackoffBase
+	}
+
+	if o.BackoffFactor > 0 {
+		p.BackoffFactor = o.BackoffFactor
+	}
+
+	if o.BackoffMax > 0 {
+		p.BackoffMax = o.BackoffMax
+	}
+
+	if o.BackoffJitter > 0 {
+		p.BackoffJitter = o.BackoffJitter
+	}
+}
+
+func (s *HttpClient) merge(o *HttpClient) {
+	if o == nil {
+		return
+	}
+
+	if o.ConnectTimeout > 0 {
+		s.ConnectTimeout = o.ConnectTimeout
+	}
+
+	if o.TlsHandshakeTimeout > 0 {
+		s.TlsHandshakeTimeout = o.TlsHandshakeTimeout
+	}
+
+	if o.Timeout > 0 {
+		s.Timeout = o.Timeout
+	}
+
+	if o.IdleConnTimeout > 0 {
+		s.IdleConnTimeout = o.IdleConnTimeout
+	}
+
+	if o.MaxIdleConns > 0 {
+		s.MaxIdleConns = o.MaxIdleConns
+	}
+
+	if o.MaxIdleConnsPerHost > 0 {
+		s.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+
+	if o.MaxConnsPerHost > 0 {
+		s.MaxConnsPerHost = o.MaxConnsPerHost
+	}
+
+	if o.MaxRedirects != 0 {
+		s.MaxRedirects = o.MaxRedirects
+	}
+}
+
+func (p *Projects) Decode(value string) error {
+	if err := json.Unmarshal([]byte(value), p); err != nil {
+		return err
+	}
+
+	for id, project := range *p {
+		if project == nil {
+			continue
+		}
+
+		if project.SignatureScheme != "" && !isSignatureScheme(project.SignatureScheme) {
+			return fmt.Errorf(errorSignatureSchemeInvalid, project.SignatureScheme, id)
+		}
+
+		switch project.PayloadMode {
+		case "", PayloadModeFull, PayloadModeThin:
+		default:
+			return fmt.Errorf(errorPayloadModeInvalid, project.PayloadMode, id)
+		}
+
+		switch project.PayloadVersion {
+		case "", PayloadVersionV1, PayloadVersionV2, PayloadVersionV3:
+		default:
+			return fmt.Errorf(errorPayloadVersionInvalid, project.PayloadVersion, id)
+		}
+
+		switch project.CloudEventsMode {
+		case "", CloudEventsModeBinary, CloudEventsModeStructured:
+		default:
+			return fmt.Errorf(errorCloudEventsModeInvalid, project.CloudEventsMode, id)
+		}
+
+		if err := project.Template.validate(id); err != nil {
+			return err
+		}
+
+		for _, endpoint := range project.Endpoints {
+			if endpoint == nil || endpoint.Url == "" {
+				return fmt.Errorf(errorEndpointUrlEmpty, id)
+			}
+		}
+
+		for _, rule := range project.Responses {
+			if rule == nil {
+				continue
+			}
+
+			switch rule.Result {
+			case ResponseResultSuccess, ResponseResultReject, ResponseResultRetry:
+			default:
+				return fmt.Errorf(errorResponseResultInvalid, rule.Result, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isSignatureScheme(scheme string) bool {
+	return scheme == SignatureSchemeLegacy || scheme == SignatureSchemeHmacSha256
+}
+
+func (t *Template) validate(id string) error {
+	if t == nil {
+		return nil
+	}
+
+	if t.Body == "" {
+		return fmt.Errorf(errorTemplateBodyEmpty, id)
+	}
+
+	if t.Signature == nil {
+		return nil
+	}
+
+	switch t.Signature.Algorithm {
+	case TemplateSignatureMd5, TemplateSignatureSha1, TemplateSignatureSha256, TemplateSignatureSha512,
+		TemplateSignatureHmacSha1, TemplateSignatureHmacSha256, TemplateSignatureHmacSha512:
+	default:
+		return fmt.Errorf(errorTemplateAlgorithm, t.Signature.Algorithm, id)
+	}
+
+	switch t.Signature.Encoding {
+	case "", TemplateSignatureEncodingHex, TemplateSignatureEncodingBase64:
+	default:
+		return fmt.Errorf(errorTemplateEncoding, t.Signature.Encoding, id)
+	}
+
+	return nil
+}
+
+// ParseTemplates parses templates of the template protocol of all projects with the helper functions
+func (p Projects) ParseTemplates(funcs template.FuncMap) error {
+	for id, project := range p {
+		if project == nil || project.Template == nil {
+			continue
+		}
+
+		if err := project.Template.Parse(id, funcs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parse parses all templates of the request with the helper functions
+func (t *Template) Parse(id string, funcs template.FuncMap) error {
+	texts := map[string]string{TemplateNameBody: t.Body}
+
+	for name, text := range t.Headers {
+		texts[TemplateNameHeader(name)] = text
+	}
+
+	if t.Signature != nil {
+		if t.Signature.Payload != "" {
+			texts[TemplateNameSignaturePayload] = t.Signature.Payload
+		}
+
+		if t.Signature.Key != "" {
+			texts[TemplateNameSignatureKey] = t.Signature.Key
+		}
+	}
+
+	parsed := template.New(TemplateNameBody).Funcs(funcs).Option(templateOptionMissingKeyError)
+
+	for name, text := range texts {
+		if _, err := parsed.New(name).Parse(text); err != nil {
+			return fmt.Errorf(errorTemplateInvalid, name, id, err)
+		}
+	}
+
+	t.parsed = parsed
+
+	return nil
+}
+
+// HasTemplate checks that the template with the name is parsed
+func (t *Template) HasTemplate(name string) bool {
+	return t.parsed != nil && t.parsed.Lookup(name) != nil
+}
+
+// Execute executes the parsed template with the name over the data
+func (t *Template) Execute(name string, data interface{}) (string, error) {
+	if !t.HasTemplate(name) {
+		return "", errors.New(errorTemplateNotParsed)
+	}
+
+	buf := &strings.Builder{}
+
+	if err := t.parsed.ExecuteTemplate(buf, name, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
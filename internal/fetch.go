@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"encoding/hex"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fetchRoutePrefix  = "/orders/"
+	fetchRouteSuffix  = "/payload"
+	errorFetchInvalid = "fetch url is invalid or expired"
+	errorFetchFailed  = "payload fetch failed"
+)
+
+func (app *NotifierApplication) initFetch() {
+	app.fetchRouter = http.NewServeMux()
+	app.fetchRouter.HandleFunc(fetchRoutePrefix, app.fetchPayload)
+}
+
+// fetchPayload returns payload of the order by the signed url sent in the thin notification. The route is public,
+// so the url is checked before the order is requested and every invalid url gets the same response: it tells
+// neither whether the order exists nor what is wrong with the url.
+func (app *NotifierApplication) fetchPayload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJson(w, http.StatusMethodNotAllowed, &adminErrorResponse{Error: errorAdminMethodNotAllowed})
+		return
+	}
+
+	orderId, expires, token, ok := app.parseFetchUrl(r, time.Now())
+
+	if !ok {
+		writeJson(w, http.StatusForbidden, &adminErrorResponse{Error: errorFetchInvalid})
+		return
+	}
+
+	rsp, err := app.repo.GetOrderPrivate(r.Context(), &billingpb.GetOrderRequest{OrderId: orderId})
+
+	if err != nil {
+		app.log.Error("Get order for payload fetch failed", zap.Error(err), zap.String("order_id", orderId))
+		writeJson(w, http.StatusInternalServerError, &adminErrorResponse{Error: errorFetchFailed})
+		return
+	}
+
+	o := rsp.GetItem()
+
+	if rsp.Status != billingpb.ResponseStatusOk || o == nil ||
+		!handler.CheckPayloadFetchToken(o.GetProject().GetSecretKey(), o.Id, expires, token, time.Now()) {
+		writeJson(w, http.StatusForbidden, &adminErrorResponse{Error: errorFetchInvalid})
+		return
+	}
+
+	payload, err := app.newHandler(o, amqp.Delivery{}).GetOrderPayload()
+
+	if err != nil {
+		app.log.Error("Order payload projection failed", zap.Error(err), zap.String("order_id", orderId))
+		writeJson(w, http.StatusInternalServerError, &adminErrorResponse{Error: errorFetchFailed})
+		return
+	}
+
+	writeJson(w, http.StatusOK, payload)
+}
+
+// parseFetchUrl returns the order identifier, the expiration time and the token of the fetch url if the url
+// has the shape of the signed url and isn't expired. The token is checked against the order later.
+func (app *NotifierApplication) parseFetchUrl(r *http.Request, now time.Time) (string, int64, string, bool) {
+	path := strings.TrimPrefix(r.URL.Path, fetchRoutePrefix)
+
+	if !strings.HasSuffix(path, fetchRouteSuffix) {
+		return "", 0, "", false
+	}
+
+	orderId := strings.TrimSuffix(path, fetchRouteSuffix)
+
+	if orderId == "" || strings.Contains(orderId, "/") {
+		return "", 0, "", false
+	}
+
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get(handler.PayloadFetchQueryExpires), 10, 64)
+
+	// urls are never signed for longer than the fetch url lifetime
+	if err != nil || expires < now.Unix() || expires > now.Unix()+app.cfg.PayloadFetchTtl {
+		return "", 0, "", false
+	}
+
+	token := query.Get(handler.PayloadFetchQueryToken)
+
+	if _, err := hex.DecodeString(token); err != nil || len(token) != handler.PayloadFetchTokenLength {
+		return "", 0, "", false
+	}
+
+	return orderId, expires, token, true
+}
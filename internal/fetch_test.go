@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"fmt"
+	billMocks "github.com/paysuper/paysuper-proto/go/billingpb/mocks"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	fetchTestOrderId = "254e3736-000f-5000-8000-178d1d80bf70"
+)
+
+type FetchTestSuite struct {
+	suite.Suite
+	app     *NotifierApplication
+	billing *billMocks.BillingService
+}
+
+func Test_Fetch(t *testing.T) {
+	suite.Run(t, new(FetchTestSuite))
+}
+
+func (suite *FetchTestSuite) SetupTest() {
+	cfg, err := config.NewConfig()
+	assert.NoError(suite.T(), err)
+
+	suite.billing = &billMocks.BillingService{}
+	suite.app = &NotifierApplication{
+		cfg:  cfg,
+		log:  zap.NewNop(),
+		repo: suite.billing,
+	}
+	suite.app.initFetch()
+}
+
+func (suite *FetchTestSuite) request(method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	suite.app.fetchRouter.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+
+	return w
+}
+
+func (suite *FetchTestSuite) TestFetch_InvalidUrl_NoOrderLookup() {
+	expires := time.Now().Add(time.Minute).Unix()
+	path := fmt.Sprintf(handler.PayloadFetchPathMask, fetchTestOrderId)
+	token := handler.GetPayloadFetchToken("Unit Test", fetchTestOrderId, expires)
+
+	urls := []string{
+		fmt.Sprintf("%s?expires=%d&token=%s", path, expires, "invalid"),
+		fmt.Sprintf("%s?expires=%d&token=%s", path, expires, strings.Repeat("z", handler.PayloadFetchTokenLength)),
+		fmt.Sprintf("%s?expires=%d&token=%s", path, time.Now().Add(-time.Minute).Unix(), token),
+		fmt.Sprintf("%s?expires=%d&token=%s", path, time.Now().Add(time.Duration(suite.app.cfg.PayloadFetchTtl+60)*time.Second).Unix(), token),
+		fmt.Sprintf("%s?token=%s", path, token),
+		fmt.Sprintf("/orders/%s?expires=%d&token=%s", fetchTestOrderId, expires, token),
+	}
+
+	for _, u := range urls {
+		w := suite.request(http.MethodGet, u)
+		assert.Equal(suite.T(), http.StatusForbidden, w.Code, u)
+		assert.Contains(suite.T(), w.Body.String(), errorFetchInvalid, u)
+	}
+
+	suite.billing.AssertNotCalled(suite.T(), "GetOrderPrivate", mock2.Anything, mock2.Anything, mock2.Anything)
+}
+
+func (suite *FetchTestSuite) TestFetch_MethodNotAllowed() {
+	w := suite.request(http.MethodPost, fmt.Sprintf(handler.PayloadFetchPathMask, fetchTestOrderId))
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"time"
+)
+
+const (
+	attemptsOrderKeyMask   = "ps:attempts:order:%s"
+	attemptsProjectKeyMask = "ps:attempts:project:%s"
+
+	attemptBodyTruncatedSuffix = "...(truncated)"
+	// Request body of the protocols which send the payment method data of the order isn't stored
+	attemptBodyOmitted = "(omitted)"
+
+	LoggerDeliveryAttemptSave = "Save delivery attempt to redis failed"
+)
+
+// DeliveryAttempt is the record of a single http request to a project
+type DeliveryAttempt struct {
+	OrderId        string            `json:"order_id"`
+	ProjectId      string            `json:"project_id"`
+	Protocol       string            `json:"protocol"`
+	Method         string            `json:"method"`
+	Url            string            `json:"url"`
+	RetryCount     int32             `json:"retry_count"`
+	RequestHeaders map[string]string `json:"request_headers"`
+	RequestBody    string            `json:"request_body"`
+	ResponseStatus int               `json:"response_status"`
+	ResponseBody   string            `json:"response_body"`
+	LatencyMs      int64             `json:"latency_ms"`
+	Error          string            `json:"error,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// GetOrderDeliveryAttempts returns the latest delivery attempts of the order, newest first
+func GetOrderDeliveryAttempts(rdb *redis.Client, orderId string, limit int64) ([]*DeliveryAttempt, error) {
+	return getDeliveryAttempts(rdb, fmt.Sprintf(attemptsOrderKeyMask, orderId), limit)
+}
+
+// GetProjectDeliveryAttempts returns the latest delivery attempts of all orders of the project, newest first
+func GetProjectDeliveryAttempts(rdb *redis.Client, projectId string, limit int64) ([]*DeliveryAttempt, error) {
+	return getDeliveryAttempts(rdb, fmt.Sprintf(attemptsProjectKeyMask, projectId), limit)
+}
+
+func getDeliveryAttempts(rdb *redis.Client, key string, limit int64) ([]*DeliveryAttempt, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	items, err := rdb.LRange(key, 0, limit-1).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make([]*DeliveryAttempt, 0, len(items))
+
+	for _, item := range items {
+		attempt := &DeliveryAttempt{}
+
+		if err := json.Unmarshal([]byte(item), attempt); err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+func (h *Handler) newDeliveryAttempt(
+	method, url string,
+	req []byte,
+	headers map[string]string,
+	latency time.Duration,
+) *DeliveryAttempt {
+	protocol := h.order.GetProject().GetCallbackProtocol()
+	body := attemptBodyOmitted
+
+	// bodies of the default protocol events are projected and redacted, bodies of the cardpay and
+	// the xsolla protocols contain the card and the payer data
+	if protocol != notifierHandlerCardPay && protocol != notifierHandlerXSolla {
+		body = h.truncateAttemptBody(req)
+	}
+
+	return &DeliveryAttempt{
+		OrderId:        h.order.GetId(),
+		ProjectId:      h.order.GetProject().GetId(),
+		Protocol:       protocol,
+		Method:         method,
+		Url:            url,
+		RetryCount:     h.RetryCount,
+		RequestHeaders: headers,
+		RequestBody:    body,
+		LatencyMs:      int64(latency / time.Millisecond),
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+func (h *Handler) truncateAttemptBody(b []byte) string {
+	if h.cfg == nil || h.cfg.AttemptsBodyMaxLength <= 0 || len(b) <= h.cfg.AttemptsBodyMaxLength {
+		return string(b)
+	}
+
+	return string(b[:h.cfg.AttemptsBodyMaxLength]) + attemptBodyTruncatedSuffix
+}
+
+// saveDeliveryAttempt stores the attempt in the order and the project lists limited by length and retention time
+func (h *Handler) saveDeliveryAttempt(attempt *DeliveryAttempt) {
+	if h.redis == nil || h.cfg == nil || h.cfg.AttemptsRetention <= 0 {
+		return
+	}
+
+	b, err := json.Marshal(attempt)
+
+	if err != nil {
+		h.HandleError(LoggerDeliveryAttemptSave, err, nil)
+		return
+	}
+
+	retention := time.Duration(h.cfg.AttemptsRetention) * time.Second
+	orderKey := fmt.Sprintf(attemptsOrderKeyMask, attempt.OrderId)
+	projectKey := fmt.Sprintf(attemptsProjectKeyMask, attempt.ProjectId)
+
+	pipe := h.redis.TxPipeline()
+	pipe.LPush(orderKey, b)
+	pipe.LTrim(orderKey, 0, h.cfg.AttemptsOrderMaxCount-1)
+	pipe.Expire(orderKey, retention)
+	pipe.LPush(projectKey, b)
+	pipe.LTrim(projectKey, 0, h.cfg.AttemptsProjectMaxCount-1)
+	pipe.Expire(projectKey, retention)
+
+	if _, err = pipe.Exec(); err != nil {
+		h.HandleError(LoggerDeliveryAttemptSave, err, nil)
+	}
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type AttemptsTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_Attempts(t *testing.T) {
+	suite.Run(t, new(AttemptsTestSuite))
+}
+
+func (suite *AttemptsTestSuite) SetupTest() {
+	cfg, err := config.NewConfig()
+	assert.NoError(suite.T(), err)
+
+	suite.redis = redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisHost,
+		Password: cfg.RedisPassword,
+	})
+
+	_, err = suite.redis.Ping().Result()
+	assert.NoError(suite.T(), err)
+
+	cfg.AttemptsOrderMaxCount = 2
+	cfg.AttemptsBodyMaxLength = 10
+
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id: "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{
+				Id:               "254e3736-000f-5000-8000-178d1d80bf71",
+				CallbackProtocol: "default",
+			},
+		},
+		redis:      suite.redis,
+		cfg:        cfg,
+		RetryCount: 2,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (suite *AttemptsTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_request_Saved() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, `{"status":"ok"}`))
+
+	headers := map[string]string{HeaderContentType: MIMEApplicationJSON}
+	resp, err := suite.handler.request(http.MethodPost, processUrl, []byte(`{"id":"1234567890"}`), headers)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+
+	attempts, err := GetOrderDeliveryAttempts(suite.redis, suite.handler.order.Id, 0)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), attempts, 1)
+
+	attempt := attempts[0]
+	assert.Equal(suite.T(), suite.handler.order.Id, attempt.OrderId)
+	assert.Equal(suite.T(), suite.handler.order.Project.Id, attempt.ProjectId)
+	assert.Equal(suite.T(), "default", attempt.Protocol)
+	assert.Equal(suite.T(), processUrl, attempt.Url)
+	assert.Equal(suite.T(), int32(2), attempt.RetryCount)
+	assert.Equal(suite.T(), MIMEApplicationJSON, attempt.RequestHeaders[HeaderContentType])
+	assert.Equal(suite.T(), `{"id":"123`+attemptBodyTruncatedSuffix, attempt.RequestBody)
+	assert.Equal(suite.T(), http.StatusOK, attempt.ResponseStatus)
+	assert.Equal(suite.T(), `{"status":"ok"}`, attempt.ResponseBody)
+	assert.Empty(suite.T(), attempt.Error)
+
+	attempts, err = GetProjectDeliveryAttempts(suite.redis, suite.handler.order.Project.Id, 0)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), attempts, 1)
+
+	ttl, err := suite.redis.TTL("ps:attempts:order:" + suite.handler.order.Id).Result()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), ttl > 0 && ttl <= time.Duration(suite.handler.cfg.AttemptsRetention)*time.Second)
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_request_Error() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.Error(suite.T(), err)
+
+	attempts, err := GetOrderDeliveryAttempts(suite.redis, suite.handler.order.Id, 0)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), attempts, 1)
+	assert.NotEmpty(suite.T(), attempts[0].Error)
+	assert.Equal(suite.T(), 0, attempts[0].ResponseStatus)
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_saveDeliveryAttempt_Bounded() {
+	for i := 0; i < 5; i++ {
+		suite.handler.RetryCount = int32(i)
+		suite.handler.saveDeliveryAttempt(suite.handler.newDeliveryAttempt(http.MethodPost, processUrl, nil, nil, 0))
+	}
+
+	attempts, err := GetOrderDeliveryAttempts(suite.redis, suite.handler.order.Id, 0)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), attempts, 2)
+	assert.Equal(suite.T(), int32(4), attempts[0].RetryCount)
+	assert.Equal(suite.T(), int32(3), attempts[1].RetryCount)
+
+	attempts, err = GetProjectDeliveryAttempts(suite.redis, suite.handler.order.Project.Id, 3)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), attempts, 3)
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_truncateAttemptBody() {
+	assert.Equal(suite.T(), "short", suite.handler.truncateAttemptBody([]byte("short")))
+	assert.True(suite.T(), strings.HasSuffix(suite.handler.truncateAttemptBody([]byte("very long body")), attemptBodyTruncatedSuffix))
+
+	suite.handler.cfg.AttemptsBodyMaxLength = 0
+	assert.Equal(suite.T(), "very long body", suite.handler.truncateAttemptBody([]byte("very long body")))
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_newDeliveryAttempt_BodyOmitted() {
+	body := []byte(`{"card":"4000000000000002"}`)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerCardPay
+	attempt := suite.handler.newDeliveryAttempt(http.MethodPost, processUrl, body, nil, 0)
+	assert.Equal(suite.T(), attemptBodyOmitted, attempt.RequestBody)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerXSolla
+	attempt = suite.handler.newDeliveryAttempt(http.MethodPost, processUrl, body, nil, 0)
+	assert.Equal(suite.T(), attemptBodyOmitted, attempt.RequestBody)
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_request_ResponseBodyLimited() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewBytesResponder(
+		http.StatusOK,
+		bytes.Repeat([]byte("a"), responseBodyMaxLength+100),
+	))
+
+	resp, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), b, responseBodyMaxLength)
+}
+
+func (suite *AttemptsTestSuite) TestAttempts_truncateLogBody() {
+	assert.Equal(suite.T(), []byte("short"), truncateLogBody([]byte("short")))
+
+	b := truncateLogBody(bytes.Repeat([]byte("a"), logBodyMaxLength+1))
+	assert.Len(suite.T(), b, logBodyMaxLength+len(attemptBodyTruncatedSuffix))
+	assert.True(suite.T(), bytes.HasSuffix(b, []byte(attemptBodyTruncatedSuffix)))
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	breakerFailuresKeyMask = "ps:breaker:failures:%s:%s"
+	breakerOpenKeyMask     = "ps:breaker:open:%s:%s"
+	breakerTrippedKeyMask  = "ps:breaker:tripped:%s:%s"
+	breakerProbeKeyMask    = "ps:breaker:probe:%s:%s"
+
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half-open"
+
+	LoggerBreakerRedis = "Circuit breaker state in redis failed"
+	loggerBreakerOpen  = "Circuit breaker of project endpoint opened"
+)
+
+// ErrCircuitOpen is returned instead of the http request when the circuit breaker of the endpoint is open
+var ErrCircuitOpen = errors.New("circuit breaker of project endpoint is open")
+
+// breaker is the circuit breaker of the project endpoint with the state shared through redis.
+// The breaker opens after the failure threshold is reached within the failure window. When the open timeout
+// expires the breaker becomes half-open and only one probe request at a time is allowed. Successful probe closes
+// the breaker, failed probe opens it again.
+type breaker struct {
+	redis       *redis.Client
+	threshold   int64
+	window      time.Duration
+	openTimeout time.Duration
+	host        string
+	failuresKey string
+	openKey     string
+	trippedKey  string
+	probeKey    string
+	probe       bool
+}
+
+// getBreaker returns circuit breaker of the url host and the order project or nil if breaker is disabled
+func (h *Handler) getBreaker(reqUrl string) *breaker {
+	if h.redis == nil || h.cfg == nil || h.cfg.BreakerFailureThreshold <= 0 {
+		return nil
+	}
+
+	u, err := url.Parse(reqUrl)
+
+	if err != nil || u.Host == "" {
+		return nil
+	}
+
+	projectId := h.order.GetProject().GetId()
+
+	return &breaker{
+		redis:       h.redis,
+		threshold:   h.cfg.BreakerFailureThreshold,
+		window:      time.Duration(h.cfg.BreakerFailureWindow) * time.Second,
+		openTimeout: time.Duration(h.cfg.BreakerOpenTimeout) * time.Second,
+		host:        u.Host,
+		failuresKey: fmt.Sprintf(breakerFailuresKeyMask, projectId, u.Host),
+		openKey:     fmt.Sprintf(breakerOpenKeyMask, projectId, u.Host),
+		trippedKey:  fmt.Sprintf(breakerTrippedKeyMask, projectId, u.Host),
+		probeKey:    fmt.Sprintf(breakerProbeKeyMask, projectId, u.Host),
+	}
+}
+
+// GetState returns current state of the breaker
+func (b *breaker) GetState() (string, error) {
+	open, err := b.redis.Exists(b.openKey).Result()
+
+	if err != nil {
+		return "", err
+	}
+
+	if open > 0 {
+		return BreakerStateOpen, nil
+	}
+
+	tripped, err := b.redis.Exists(b.trippedKey).Result()
+
+	if err != nil {
+		return "", err
+	}
+
+	if tripped > 0 {
+		return BreakerStateHalfOpen, nil
+	}
+
+	return BreakerStateClosed, nil
+}
+
+// Allow checks that request to the endpoint is allowed. In half-open state the request is allowed
+// only if the probe slot is acquired, the slot is released on the request result.
+func (b *breaker) Allow() error {
+	state, err := b.GetState()
+
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case BreakerStateOpen:
+		return ErrCircuitOpen
+	case BreakerStateHalfOpen:
+		ok, err := b.redis.SetNX(b.probeKey, 1, b.openTimeout).Result()
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrCircuitOpen
+		}
+
+		b.probe = true
+	}
+
+	return nil
+}
+
+// Success closes the breaker
+func (b *breaker) Success() error {
+	return b.redis.Del(b.failuresKey, b.openKey, b.trippedKey, b.probeKey).Err()
+}
+
+// Failure counts the failure within the failure window and opens the breaker when the threshold is reached.
+// Failed probe opens the breaker immediately. Returns true if the breaker was opened.
+func (b *breaker) Failure() (bool, error) {
+	if !b.probe {
+		pipe := b.redis.TxPipeline()
+		incr := pipe.Incr(b.failuresKey)
+		pipe.Expire(b.failuresKey, b.window)
+
+		if _, err := pipe.Exec(); err != nil {
+			return false, err
+		}
+
+		if incr.Val() < b.threshold {
+			return false, nil
+		}
+	}
+
+	// half-open state lasts while the probe slot does, so the breaker closes if the probe result is lost
+	pipe := b.redis.TxPipeline()
+	pipe.Set(b.openKey, 1, b.openTimeout)
+	pipe.Set(b.trippedKey, 1, 2*b.openTimeout)
+	pipe.Del(b.failuresKey, b.probeKey)
+
+	if _, err := pipe.Exec(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// breakerAllow checks the circuit breaker of the endpoint before the http request.
+// Manual resend is always allowed and its result is recorded like the probe result.
+func (h *Handler) breakerAllow(b *breaker) error {
+	if b == nil || h.resend {
+		return nil
+	}
+
+	err := b.Allow()
+
+	if err == nil || errors.Is(err, ErrCircuitOpen) {
+		return err
+	}
+
+	// breaker must not block deliveries when its state is unavailable
+	h.HandleError(LoggerBreakerRedis, err, nil)
+
+	return nil
+}
+
+// breakerRelease releases the probe slot of the half-open breaker when the request wasn't sent
+func (h *Handler) breakerRelease(b *breaker) {
+	if b == nil || !b.probe {
+		return
+	}
+
+	if err := h.redis.Del(b.probeKey).Err(); err != nil {
+		h.HandleError(LoggerBreakerRedis, err, nil)
+	}
+}
+
+// breakerResult records result of the http request in the circuit breaker of the endpoint.
+// Network errors and 5xx responses are counted as failures. Throttling response is neither a failure
+// nor a success: the endpoint is alive, but it doesn't prove the endpoint recovered.
+func (h *Handler) breakerResult(b *breaker, resp *http.Response, err error) {
+	if b == nil {
+		return
+	}
+
+	if errors.Is(err, ErrAddressForbidden) {
+		return
+	}
+
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		h.breakerRelease(b)
+		return
+	}
+
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		if err := b.Success(); err != nil {
+			h.HandleError(LoggerBreakerRedis, err, nil)
+		}
+		return
+	}
+
+	opened, err := b.Failure()
+
+	if err != nil {
+		h.HandleError(LoggerBreakerRedis, err, nil)
+		return
+	}
+
+	if opened {
+		metrics.BreakerOpenedTotal.WithLabelValues(h.order.GetProject().GetCallbackProtocol()).Inc()
+		zap.S().Warnw(loggerBreakerOpen, "project_id", h.order.GetProject().GetId(), "host", b.host)
+	}
+}
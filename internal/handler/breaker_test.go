@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+type BreakerTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_Breaker(t *testing.T) {
+	suite.Run(t, new(BreakerTestSuite))
+}
+
+func (suite *BreakerTestSuite) SetupTest() {
+	cfg, err := config.NewConfig()
+	assert.NoError(suite.T(), err)
+
+	suite.redis = redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisHost,
+		Password: cfg.RedisPassword,
+	})
+
+	_, err = suite.redis.Ping().Result()
+	assert.NoError(suite.T(), err)
+
+	cfg.BreakerFailureThreshold = 2
+
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id: "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{
+				Id:               "254e3736-000f-5000-8000-178d1d80bf71",
+				CallbackProtocol: "default",
+			},
+		},
+		redis:      suite.redis,
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (suite *BreakerTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *BreakerTestSuite) TestBreaker_Open() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusServiceUnavailable, ""))
+
+	for i := 0; i < 2; i++ {
+		_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+		assert.NoError(suite.T(), err)
+	}
+
+	state, err := suite.handler.getBreaker(processUrl).GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateOpen, state)
+
+	_, err = suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.True(suite.T(), errors.Is(err, ErrCircuitOpen))
+	assert.Equal(suite.T(), 2, httpmock.GetTotalCallCount())
+}
+
+func (suite *BreakerTestSuite) TestBreaker_OtherProject_Closed() {
+	b := suite.handler.getBreaker(processUrl)
+
+	for i := 0; i < 2; i++ {
+		_, err := b.Failure()
+		assert.NoError(suite.T(), err)
+	}
+
+	suite.handler.order.Project.Id = "254e3736-000f-5000-8000-178d1d80bf72"
+
+	state, err := suite.handler.getBreaker(processUrl).GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateClosed, state)
+}
+
+func (suite *BreakerTestSuite) TestBreaker_HalfOpen_SingleProbe() {
+	b := suite.handler.getBreaker(processUrl)
+	suite.trip(b)
+
+	assert.NoError(suite.T(), b.Allow())
+	assert.True(suite.T(), b.probe)
+
+	other := suite.handler.getBreaker(processUrl)
+	assert.True(suite.T(), errors.Is(other.Allow(), ErrCircuitOpen))
+
+	assert.NoError(suite.T(), b.Success())
+
+	state, err := b.GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateClosed, state)
+	assert.NoError(suite.T(), other.Allow())
+}
+
+func (suite *BreakerTestSuite) TestBreaker_HalfOpen_ProbeFailed() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+
+	suite.trip(suite.handler.getBreaker(processUrl))
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, httpmock.GetTotalCallCount())
+
+	state, err := suite.handler.getBreaker(processUrl).GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateOpen, state)
+}
+
+func (suite *BreakerTestSuite) TestBreaker_TooManyRequests_Neutral() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusTooManyRequests, ""))
+
+	b := suite.handler.getBreaker(processUrl)
+	_, err := b.Failure()
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+
+	// the failure isn't reset by the throttled request
+	failures, err := suite.redis.Get(b.failuresKey).Int64()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), failures)
+
+	// the throttled probe neither closes nor opens the breaker, the next probe is allowed
+	suite.trip(b)
+
+	_, err = suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+
+	state, err := b.GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateHalfOpen, state)
+	assert.NoError(suite.T(), suite.handler.getBreaker(processUrl).Allow())
+}
+
+func (suite *BreakerTestSuite) TestBreaker_Tripped_Expires() {
+	b := suite.handler.getBreaker(processUrl)
+	suite.trip(b)
+
+	ttl, err := suite.redis.TTL(b.trippedKey).Result()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), ttl > b.openTimeout && ttl <= 2*b.openTimeout)
+}
+
+func (suite *BreakerTestSuite) TestBreaker_Resend_Allowed() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	b := suite.handler.getBreaker(processUrl)
+
+	for i := 0; i < 2; i++ {
+		_, err := b.Failure()
+		assert.NoError(suite.T(), err)
+	}
+
+	suite.handler.resend = true
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+
+	state, err := b.GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateClosed, state)
+}
+
+func (suite *BreakerTestSuite) TestBreaker_Disabled() {
+	suite.handler.cfg.BreakerFailureThreshold = 0
+	assert.Nil(suite.T(), suite.handler.getBreaker(processUrl))
+
+	suite.handler.cfg.BreakerFailureThreshold = 2
+	suite.handler.redis = nil
+	assert.Nil(suite.T(), suite.handler.getBreaker(processUrl))
+}
+
+// trip opens the breaker and expires the open timeout, so the breaker becomes half-open
+func (suite *BreakerTestSuite) trip(b *breaker) {
+	for i := 0; i < 2; i++ {
+		_, err := b.Failure()
+		assert.NoError(suite.T(), err)
+	}
+
+	assert.NoError(suite.T(), suite.redis.Del(b.openKey).Err())
+
+	state, err := b.GetState()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), BreakerStateHalfOpen, state)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timestampType = reflect.TypeOf(timestamp.Timestamp{})
+
+// MarshalCanonical returns canonical json of the webhook payload: object keys are sorted, html characters
+// are not escaped and there is no trailing new line
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// FormatTimestamp returns time in the RFC3339 format in UTC used in webhook payloads
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// canonicalValue converts the value to the generic json value independent of the protobuf library encoding.
+// Struct fields are named by the json tags and emitted regardless of omitempty, protobuf timestamps are formatted
+// as RFC3339 strings, nil structs and timestamps are null, nil slices and maps are empty.
+func canonicalValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return canonicalValue(v.Elem())
+	case reflect.Struct:
+		if v.Type() == timestampType {
+			ts := v.Interface().(timestamp.Timestamp)
+			return FormatTimestamp(time.Unix(ts.Seconds, int64(ts.Nanos)))
+		}
+
+		res := make(map[string]interface{})
+		canonicalStruct(v, res)
+
+		return res
+	case reflect.Map:
+		res := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+
+		for iter.Next() {
+			res[fmt.Sprint(iter.Key().Interface())] = canonicalValue(iter.Value())
+		}
+
+		return res
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes())
+		}
+
+		res := make([]interface{}, 0, v.Len())
+
+		for i := 0; i < v.Len(); i++ {
+			res = append(res, canonicalValue(v.Index(i)))
+		}
+
+		return res
+	}
+
+	return v.Interface()
+}
+
+// canonicalStruct adds exported fields of the struct with json names to the map, fields of embedded structs
+// without json name are added as own fields
+func canonicalStruct(v reflect.Value, res map[string]interface{}) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+
+		if f.PkgPath != "" || tag == "-" || strings.HasPrefix(f.Name, "XXX_") {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			canonicalStruct(v.Field(i), res)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		res[name] = canonicalValue(v.Field(i))
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"reflect"
+	"testing"
+)
+
+type CanonicalTestSuite struct {
+	suite.Suite
+	order *billingpb.Order
+}
+
+func Test_Canonical(t *testing.T) {
+	suite.Run(t, new(CanonicalTestSuite))
+}
+
+func (suite *CanonicalTestSuite) SetupTest() {
+	suite.order = &billingpb.Order{
+		Id:        "254e3736-000f-5000-8000-178d1d80bf70",
+		Currency:  "RUB",
+		CreatedAt: &timestamp.Timestamp{Seconds: 1580000000, Nanos: 500},
+		Project: &billingpb.ProjectOrder{
+			Id: "254e3736-000f-5000-8000-178d1d80bf73",
+		},
+		Metadata: map[string]string{"a": "<b>"},
+	}
+}
+
+func (suite *CanonicalTestSuite) TearDownTest() {}
+
+func (suite *CanonicalTestSuite) TestCanonical_canonicalValue_Timestamps() {
+	v := canonicalValue(reflect.ValueOf(suite.order)).(map[string]interface{})
+
+	assert.Equal(suite.T(), "2020-01-26T00:53:20Z", v["created_at"])
+	assert.Contains(suite.T(), v, "payment_method_order_closed_at")
+	assert.Nil(suite.T(), v["payment_method_order_closed_at"])
+}
+
+func (suite *CanonicalTestSuite) TestCanonical_canonicalValue_ZeroValues() {
+	v := canonicalValue(reflect.ValueOf(suite.order)).(map[string]interface{})
+
+	assert.Contains(suite.T(), v, "project_order_id")
+	assert.Equal(suite.T(), "", v["project_order_id"])
+	assert.Contains(suite.T(), v, "user")
+	assert.Nil(suite.T(), v["user"])
+	assert.Equal(suite.T(), suite.order.Project.Id, v["project"].(map[string]interface{})["id"])
+
+	for k := range v {
+		assert.NotContains(suite.T(), k, "XXX_")
+	}
+}
+
+func (suite *CanonicalTestSuite) TestCanonical_MarshalCanonical_Deterministic() {
+	v := map[string]interface{}{
+		"b": canonicalValue(reflect.ValueOf(suite.order.Metadata)),
+		"a": canonicalValue(reflect.ValueOf(suite.order.CreatedAt)),
+	}
+
+	b1, err := MarshalCanonical(v)
+	assert.NoError(suite.T(), err)
+
+	b2, err := MarshalCanonical(v)
+	assert.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), b1, b2)
+	assert.Equal(suite.T(), `{"a":"2020-01-26T00:53:20Z","b":{"a":"<b>"}}`, string(b1))
+}
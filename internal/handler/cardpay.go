@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"context"
 	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
@@ -9,6 +8,7 @@ import (
 	"fmt"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
 	"net/http"
 	"strconv"
 	"time"
@@ -67,18 +67,19 @@ func (n *CardPay) Notify() error {
 		return n.handleErrorWithRetry(loggerErrorNotificationRetry, err, nil)
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
+	switch n.classifyResponse(resp) {
+	case config.ResponseResultSuccess:
 		n.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
 		break
-	case http.StatusUnprocessableEntity:
+	case config.ResponseResultReject:
 		n.order.PrivateStatus = recurringpb.OrderStatusProjectReject
 		break
 	default:
+		err = fmt.Errorf(errorNotificationNeedRetry, n.order.GetId(), NotificationActionPayment)
 		return n.handleErrorWithRetry(loggerErrorNotificationRetry, err, nil)
 	}
 
-	if _, err = n.repository.UpdateOrder(context.TODO(), n.order); err != nil {
+	if err = n.updateOrder(n.order); err != nil {
 		n.HandleError(loggerErrorNotificationUpdate, err, nil)
 	}
 
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/centrifugal/gocent"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
 	"go.uber.org/zap"
 	"net/http"
 )
@@ -35,6 +36,7 @@ func (c *Centrifugo) Publish(ctx context.Context, channel string, msg interface{
 	b, err := json.Marshal(msg)
 
 	if err != nil {
+		metrics.CentrifugoPublishFailuresTotal.Inc()
 		zap.L().Error(
 			"Publish message to centrifugo failed",
 			zap.Error(err),
@@ -44,5 +46,9 @@ func (c *Centrifugo) Publish(ctx context.Context, channel string, msg interface{
 		return err
 	}
 
-	return c.centrifugoClient.Publish(ctx, channel, b)
+	if err = c.centrifugoClient.Publish(ctx, channel, b); err != nil {
+		metrics.CentrifugoPublishFailuresTotal.Inc()
+	}
+
+	return err
 }
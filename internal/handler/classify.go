@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var (
+	// Response classification rules of callback protocols. Responses which don't match any rule are retried.
+	responseRules = map[string][]*config.ResponseRule{
+		notifierHandlerDefault: {
+			{Status: []int{http.StatusOK, http.StatusNoContent}, Result: config.ResponseResultSuccess},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+		notifierHandlerCardPay: {
+			{Status: []int{http.StatusOK}, Result: config.ResponseResultSuccess},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+		notifierHandlerXSolla: {
+			{Status: []int{http.StatusOK, http.StatusNoContent}, Result: config.ResponseResultSuccess},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+		// any 2xx response is the successful delivery by the specification
+		notifierHandlerStandardWebhooks: {
+			{
+				Status: []int{http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent},
+				Result: config.ResponseResultSuccess,
+			},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+		notifierHandlerTemplate: {
+			{Status: []int{http.StatusOK, http.StatusNoContent}, Result: config.ResponseResultSuccess},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+		notifierHandlerCloudEvents: {
+			{
+				Status: []int{http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent},
+				Result: config.ResponseResultSuccess,
+			},
+			{Status: []int{http.StatusUnprocessableEntity}, Result: config.ResponseResultReject},
+		},
+	}
+)
+
+// classifyResponse returns result of the notification delivery by the project response.
+// Rules of the project are checked first, then rules of the callback protocol.
+func (h *Handler) classifyResponse(resp *http.Response) string {
+	body := readResponseBody(resp)
+	project := h.cfg.GetProject(h.order.GetProject().GetId()).Responses
+	protocol := responseRules[h.order.GetProject().GetCallbackProtocol()]
+
+	for _, rules := range [][]*config.ResponseRule{project, protocol} {
+		for _, rule := range rules {
+			if matchResponseRule(rule, resp.StatusCode, body) {
+				return rule.Result
+			}
+		}
+	}
+
+	return config.ResponseResultRetry
+}
+
+func matchResponseRule(rule *config.ResponseRule, status int, body []byte) bool {
+	if rule == nil {
+		return false
+	}
+
+	if len(rule.Status) > 0 {
+		matched := false
+
+		for _, v := range rule.Status {
+			if v == status {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if rule.BodyContains != "" && !strings.Contains(string(body), rule.BodyContains) {
+		return false
+	}
+
+	if len(rule.BodyJson) > 0 {
+		fields := make(map[string]interface{})
+
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return false
+		}
+
+		for k, v := range rule.BodyJson {
+			if !reflect.DeepEqual(fields[k], v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// readResponseBody returns the response body and restores it for further reading
+func readResponseBody(resp *http.Response) []byte {
+	if resp.Body == nil {
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(b))
+
+	return b
+}
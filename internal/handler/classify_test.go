@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type ClassifyTestSuite struct {
+	suite.Suite
+	handler *Handler
+}
+
+func Test_Classify(t *testing.T) {
+	suite.Run(t, new(ClassifyTestSuite))
+}
+
+func (suite *ClassifyTestSuite) SetupTest() {
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id: "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{
+				Id:               "254e3736-000f-5000-8000-178d1d80bf71",
+				CallbackProtocol: notifierHandlerDefault,
+			},
+		},
+		cfg: &config.Config{},
+	}
+}
+
+func (suite *ClassifyTestSuite) TearDownTest() {}
+
+func (suite *ClassifyTestSuite) TestClassify_ProtocolRules() {
+	assert.Equal(suite.T(), config.ResponseResultSuccess, suite.classify(http.StatusOK, ""))
+	assert.Equal(suite.T(), config.ResponseResultSuccess, suite.classify(http.StatusNoContent, ""))
+	assert.Equal(suite.T(), config.ResponseResultReject, suite.classify(http.StatusUnprocessableEntity, ""))
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusBadRequest, ""))
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusInternalServerError, ""))
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerCardPay
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusNoContent, ""))
+}
+
+func (suite *ClassifyTestSuite) TestClassify_ProjectRules_RequireAck() {
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Responses: []*config.ResponseRule{
+				{Status: []int{http.StatusOK}, BodyJson: map[string]interface{}{"status": "ok"}, Result: config.ResponseResultSuccess},
+				{BodyContains: "unknown order", Result: config.ResponseResultReject},
+				{Status: []int{http.StatusOK, http.StatusNoContent}, Result: config.ResponseResultRetry},
+			},
+		},
+	}
+
+	assert.Equal(suite.T(), config.ResponseResultSuccess, suite.classify(http.StatusOK, `{"status":"ok","id":1}`))
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusOK, `{"status":"pending"}`))
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusOK, `ok`))
+	assert.Equal(suite.T(), config.ResponseResultRetry, suite.classify(http.StatusNoContent, ""))
+	assert.Equal(suite.T(), config.ResponseResultReject, suite.classify(http.StatusBadRequest, "unknown order"))
+	assert.Equal(suite.T(), config.ResponseResultReject, suite.classify(http.StatusUnprocessableEntity, ""))
+}
+
+func (suite *ClassifyTestSuite) TestClassify_BodyRestored() {
+	rsp := httpmock.NewStringResponse(http.StatusOK, `{"status":"ok"}`)
+	suite.handler.classifyResponse(rsp)
+
+	b, err := ioutil.ReadAll(rsp.Body)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), `{"status":"ok"}`, string(b))
+}
+
+func (suite *ClassifyTestSuite) TestClassify_Decode_InvalidResult() {
+	projects := config.Projects{}
+	err := projects.Decode(`{"project": {"responses": [{"status": [200], "result": "accept"}]}}`)
+	assert.Error(suite.T(), err)
+
+	err = projects.Decode(`{"project": {"responses": [{"body_json": {"status": "ok"}, "result": "success"}]}}`)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "ok", projects["project"].Responses[0].BodyJson["status"])
+}
+
+func (suite *ClassifyTestSuite) classify(status int, body string) string {
+	return suite.handler.classifyResponse(httpmock.NewStringResponse(status, body))
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"time"
+)
+
+const (
+	MIMEApplicationCloudEventsJSON = "application/cloudevents+json; charset=utf-8"
+
+	HeaderCloudEventsSpecVersion = "ce-specversion"
+	HeaderCloudEventsId          = "ce-id"
+	HeaderCloudEventsType        = "ce-type"
+	HeaderCloudEventsSource      = "ce-source"
+	HeaderCloudEventsSubject     = "ce-subject"
+	HeaderCloudEventsTime        = "ce-time"
+
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsSourceMask  = "/projects/%s"
+)
+
+// CloudEvent is the event of the CloudEvents 1.0 specification in the structured content mode
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	Id              string                 `json:"id"`
+	Type            string                 `json:"type"`
+	Source          string                 `json:"source"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time,omitempty"`
+	DataContentType string                 `json:"datacontenttype"`
+	Data            map[string]interface{} `json:"data"`
+}
+
+// getCloudEvent returns the event of the message: identifier is the event id, type is the event name,
+// source is the project and time is the order update time
+func (n *Default) getCloudEvent(msg *OrderNotificationMessage) *CloudEvent {
+	event := &CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Id:              msg.Id,
+		Type:            msg.Event,
+		Source:          fmt.Sprintf(cloudEventsSourceMask, n.order.GetProject().GetId()),
+		DataContentType: MIMEApplicationJSON,
+		Data:            getNotificationData(msg),
+	}
+
+	// ping has no order
+	if msg.Event != eventNamePing {
+		event.Subject = n.order.GetId()
+	}
+
+	if t, err := ptypes.Timestamp(n.order.GetUpdatedAt()); err == nil {
+		event.Time = FormatTimestamp(t)
+	}
+
+	return event
+}
+
+// encodeCloudEventsMessage returns the message as the CloudEvent in the content mode of the project.
+// The body is signed like the default protocol.
+func (n *Default) encodeCloudEventsMessage(
+	endpoint *notificationEndpoint,
+	msg *OrderNotificationMessage,
+	now time.Time,
+) ([]byte, map[string]string, error) {
+	event := n.getCloudEvent(msg)
+	headers := map[string]string{HeaderAccept: MIMEApplicationJSON}
+
+	var v interface{} = event
+
+	if n.cfg.GetCloudEventsMode(n.order.GetProject().GetId()) == config.CloudEventsModeBinary {
+		v = event.Data
+		headers[HeaderContentType] = event.DataContentType
+		headers[HeaderCloudEventsSpecVersion] = event.SpecVersion
+		headers[HeaderCloudEventsId] = event.Id
+		headers[HeaderCloudEventsType] = event.Type
+		headers[HeaderCloudEventsSource] = event.Source
+
+		if event.Subject != "" {
+			headers[HeaderCloudEventsSubject] = event.Subject
+		}
+
+		if event.Time != "" {
+			headers[HeaderCloudEventsTime] = event.Time
+		}
+	} else {
+		headers[HeaderContentType] = MIMEApplicationCloudEventsJSON
+	}
+
+	b, err := MarshalCanonical(v)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n.setSignatureHeaders(headers, b, endpoint.secretKey, now)
+
+	return b, headers, nil
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type CloudEventsTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_CloudEvents(t *testing.T) {
+	suite.Run(t, new(CloudEventsTestSuite))
+}
+
+func (suite *CloudEventsTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerCloudEvents)
+	suite.redis = suite.handler.redis
+}
+
+func (suite *CloudEventsTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *CloudEventsTestSuite) TestCloudEvents_Binary_Ok() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(suite.T(), MIMEApplicationJSON, req.Header.Get(HeaderContentType))
+		assert.Equal(suite.T(), cloudEventsSpecVersion, req.Header.Get(HeaderCloudEventsSpecVersion))
+		assert.Len(suite.T(), req.Header.Get(HeaderCloudEventsId), 64)
+		assert.Equal(suite.T(), eventNameSuccess, req.Header.Get(HeaderCloudEventsType))
+		assert.Equal(suite.T(), "/projects/"+suite.handler.order.Project.Id, req.Header.Get(HeaderCloudEventsSource))
+		assert.Equal(suite.T(), suite.handler.order.Id, req.Header.Get(HeaderCloudEventsSubject))
+		assert.NotEmpty(suite.T(), req.Header.Get(HeaderCloudEventsTime))
+
+		ts := req.Header.Get(HeaderPaySuperTimestamp)
+		mac := hmac.New(sha256.New, []byte(suite.handler.order.Project.SecretKey))
+		mac.Write([]byte(ts + "." + string(b)))
+		assert.Equal(suite.T(), "v1="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get(HeaderPaySuperSignature))
+
+		data := make(map[string]interface{})
+		assert.NoError(suite.T(), json.Unmarshal(b, &data))
+		assert.Equal(suite.T(), suite.handler.order.Id, data["id"])
+		return httpmock.NewStringResponse(http.StatusAccepted, ""), nil
+	})
+
+	suite.handler.cfg.SignatureScheme = SignatureSchemeHmacSha256
+
+	err := newDefaultHandler(suite.handler).Notify()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *CloudEventsTestSuite) TestCloudEvents_Structured_Ok() {
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {CloudEventsMode: config.CloudEventsModeStructured},
+	}
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(suite.T(), MIMEApplicationCloudEventsJSON, req.Header.Get(HeaderContentType))
+		assert.Empty(suite.T(), req.Header.Get(HeaderCloudEventsId))
+
+		event := &CloudEvent{}
+		assert.NoError(suite.T(), json.Unmarshal(b, event))
+		assert.Equal(suite.T(), cloudEventsSpecVersion, event.SpecVersion)
+		assert.Len(suite.T(), event.Id, 64)
+		assert.Equal(suite.T(), eventNameSuccess, event.Type)
+		assert.Equal(suite.T(), "/projects/"+suite.handler.order.Project.Id, event.Source)
+		assert.Equal(suite.T(), MIMEApplicationJSON, event.DataContentType)
+		assert.Equal(suite.T(), suite.handler.order.Id, event.Data["id"])
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	err := newDefaultHandler(suite.handler).Notify()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *CloudEventsTestSuite) TestCloudEvents_getCloudEvent_Time() {
+	n := &Default{Handler: suite.handler}
+	suite.handler.order.UpdatedAt = &timestamp.Timestamp{Seconds: 1580000000}
+
+	event := n.getCloudEvent(&OrderNotificationMessage{Id: "id", Event: eventNameRefund})
+	assert.Equal(suite.T(), "2020-01-26T00:53:20Z", event.Time)
+	assert.Equal(suite.T(), eventNameRefund, event.Type)
+	assert.NotNil(suite.T(), event.Data)
+
+	suite.handler.order.UpdatedAt = nil
+	event = n.getCloudEvent(&OrderNotificationMessage{Id: "id", Event: eventNameRefund})
+	assert.Empty(suite.T(), event.Time)
+}
@@ -1,16 +1,20 @@
 package handler
 
 import (
-	"context"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-redis/redis"
 	"github.com/paysuper/paysuper-proto/go/billingpb"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
 	"go.uber.org/zap"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +22,9 @@ const (
 	errorNoEventForCurrentStatus = "no event name for current order status"
 	loggerErrorDeletedProject    = "project is deleted"
 	loggerErrorProjectUrlEmpty   = "project url empty"
+	loggerEventNotSubscribed     = "Project isn't subscribed to the event, notification is not sent"
+	loggerEventNoEndpoint        = "No endpoint of the project accepts the event, notification is not sent"
+	errorEventNotSubscribed      = "project isn't subscribed to the event"
 
 	eventNameSuccess    = "payment.success"
 	eventNameChargeback = "payment.chargeback"
@@ -25,13 +32,36 @@ const (
 	eventNameRefund     = "payment.refund"
 
 	centrifugoMsgNotificationUrlEmpty          = "notification url is empty"
+	centrifugoMsgNotificationNoEndpoint        = "no endpoint accepts the notification event"
 	centrifugoMsgNotificationForDeletedProject = "notification for deleted project"
 
 	psNotificationsKeyMask = "ps:notify:%s"
+	// Field of the notification stat marking delivery to the endpoint: "<public status>:<endpoint id>"
+	psNotificationsEndpointFieldMask      = "%s:%s"
+	psNotificationsEndpointFieldSeparator = ":"
+	// Field of the notification stat marking rejection by the endpoint: "<public status>:<endpoint id>:rejected"
+	psNotificationsRejectedFieldMask = "%s:rejected"
+	// Length of the hex encoded endpoint identifier
+	endpointIdLength = 16
 
 	errorNotSuccessStatus = "status is not success"
+
+	// Signature calculated as sha256(body + secret key) and sent in Authorization header
+	SignatureSchemeLegacy = config.SignatureSchemeLegacy
+	// Signature calculated as HMAC-SHA256 of "timestamp.body" with the project secret key
+	SignatureSchemeHmacSha256 = config.SignatureSchemeHmacSha256
+
+	signatureVersionV1 = "v1"
 )
 
+// Encoders of callback protocols sending events of the default protocol in their own format
+var messageEncoders = map[string]messageEncoder{
+	notifierHandlerDefault:          (*Default).encodeMessage,
+	notifierHandlerStandardWebhooks: (*Default).encodeStandardWebhooksMessage,
+	notifierHandlerCloudEvents:      (*Default).encodeCloudEventsMessage,
+	notifierHandlerTemplate:         (*Default).encodeTemplateMessage,
+}
+
 var orderPublicStatusToEventNameMapping = map[string]string{
 	recurringpb.OrderPublicStatusProcessed:  eventNameSuccess,
 	recurringpb.OrderPublicStatusChargeback: eventNameChargeback,
@@ -42,21 +72,100 @@ var orderPublicStatusToEventNameMapping = map[string]string{
 
 type Default Empty
 
+// messageEncoder returns body and headers of the request delivering the notification message to the endpoint
+type messageEncoder func(
+	n *Default,
+	endpoint *notificationEndpoint,
+	msg *OrderNotificationMessage,
+	now time.Time,
+) ([]byte, map[string]string, error)
+
+// notificationEndpoint is the url the notification is delivered to
+type notificationEndpoint struct {
+	// Identifier of the endpoint in the notification stat
+	id        string
+	url       string
+	secretKey string
+}
+
 type OrderNotificationMessage struct {
-	Id          string           `json:"id"`
-	Type        string           `json:"type"`
-	Event       string           `json:"event"`
-	Live        bool             `json:"live"`
-	CreatedAt   string           `json:"created_at"`
-	ExpiresAt   string           `json:"expires_at"`
-	DeliveryTry int32            `json:"delivery_try"`
-	Object      *billingpb.Order `json:"object"`
+	Id          string                 `json:"id"`
+	ApiVersion  string                 `json:"api_version"`
+	Type        string                 `json:"type"`
+	Event       string                 `json:"event"`
+	Live        bool                   `json:"live"`
+	CreatedAt   string                 `json:"created_at"`
+	ExpiresAt   string                 `json:"expires_at"`
+	DeliveryTry int32                  `json:"delivery_try"`
+	Object      map[string]interface{} `json:"object,omitempty"`
+	// Fields of the thin payload mode which are sent instead of the order object
+	OrderId        string `json:"order_id,omitempty"`
+	ProjectOrderId string `json:"project_order_id,omitempty"`
+	FetchUrl       string `json:"fetch_url,omitempty"`
+	FetchExpiresAt string `json:"fetch_expires_at,omitempty"`
+	// Reason of the declined payment sent with payment.declined event
+	Decline *OrderNotificationDecline `json:"decline,omitempty"`
 }
 
 func newDefaultHandler(h *Handler) Notifier {
 	return &Default{Handler: h}
 }
 
+func newNotificationEndpoint(url, secretKey string) *notificationEndpoint {
+	return &notificationEndpoint{id: GetEndpointId(url), url: url, secretKey: secretKey}
+}
+
+// GetEndpointId returns identifier of the project endpoint used in the notification stat
+func GetEndpointId(url string) string {
+	h := sha256.Sum256([]byte(url))
+
+	return hex.EncodeToString(h[:endpointIdLength/2])
+}
+
+// ResetNotificationStat removes the mark of the sent notification for the current public status of the order,
+// so the parked notification for that status is sent again. Marks of the endpoints which already received
+// the notification, including the endpoints which rejected it, are kept, so the notification is sent again
+// only to the endpoints which never received it.
+func ResetNotificationStat(rdb *redis.Client, order *billingpb.Order) error {
+	key := fmt.Sprintf(psNotificationsKeyMask, order.GetId())
+	ps := order.GetPublicStatus()
+	fields, err := rdb.HGetAll(key).Result()
+
+	if err != nil {
+		return err
+	}
+
+	del := []string{ps}
+
+	for field, val := range fields {
+		if !strings.HasPrefix(field, ps+psNotificationsEndpointFieldSeparator) {
+			continue
+		}
+
+		if !isEndpointStatField(field) || val != "1" {
+			del = append(del, field)
+		}
+	}
+
+	return rdb.HDel(key, del...).Err()
+}
+
+// isEndpointStatField checks that the field of the notification stat marks the delivery to the endpoint
+// or the rejection by it, not the notification of the order as a whole
+func isEndpointStatField(field string) bool {
+	for _, part := range strings.Split(field, psNotificationsEndpointFieldSeparator) {
+		if len(part) != endpointIdLength {
+			continue
+		}
+
+		if _, err := hex.DecodeString(part); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (n *Default) Notify() error {
 	order := n.order
 
@@ -75,77 +184,199 @@ func (n *Default) Notify() error {
 
 	ps := order.GetPublicStatus()
 
+	event := n.getEventName(stat)
+	if event == "" {
+		n.HandleError(loggerErrorNotificationMalformed, errors.New(errorNoEventForCurrentStatus), nil)
+		return errors.New(loggerErrorNotificationMalformed)
+	}
+
+	statField := n.getStatField(ps, event)
+
 	// don't send notification for current status if it already sent
-	if stat.Get(ps) == true {
+	if stat.Get(statField) == true && !n.resend {
+		n.result = metrics.ResultSkipped
 		order.SetNotificationStatus(ps, true)
-		if _, err := n.repository.UpdateOrder(context.TODO(), order); err != nil {
+		if err := n.updateOrder(order); err != nil {
 			n.HandleError(loggerErrorNotificationUpdate, err, nil)
 		}
 		return nil
 	}
 
-	req, err := n.getPaymentNotification()
-	if err != nil {
-		n.HandleError(loggerErrorNotificationMalformed, err, nil)
-		return errors.New(loggerErrorNotificationMalformed)
+	// notification which lifetime passed in the retry queue is not delivered
+	if n.isExpired(time.Now()) {
+		n.expire()
+		return nil
 	}
 
-	url := n.getNotificationUrl(ps)
-	if url == "" {
+	endpoints := n.getNotificationEndpoints(event)
+	if endpoints == nil {
 		if err := n.sendToAdminCentrifugo(order, centrifugoMsgNotificationUrlEmpty); err != nil {
 			n.HandleError(LoggerNotificationCentrifugo, err, nil)
 		}
 		return errors.New(loggerErrorProjectUrlEmpty)
 	}
 
-	resp, sendErr := n.sendRequest(url, req, NotificationActionPayment)
+	// event which the project isn't subscribed to is handled without the http request, ping is sent regardless
+	subscribed := event == eventNamePing || n.cfg.GetProject(order.GetProject().GetId()).IsSubscribed(event)
+
+	if !subscribed || len(endpoints) == 0 {
+		if n.resend {
+			return errors.New(errorEventNotSubscribed)
+		}
+
+		if subscribed {
+			// the project wants the event, but the endpoint filters drop it: most likely the settings are wrong
+			zap.S().Warnw(loggerEventNoEndpoint, "order_id", order.Id, "event", event)
 
-	if sendErr != nil {
-		return n.handleErrorWithRetry(loggerErrorNotificationRetry, sendErr, nil)
+			if err := n.sendToAdminCentrifugo(order, centrifugoMsgNotificationNoEndpoint); err != nil {
+				n.HandleError(LoggerNotificationCentrifugo, err, nil)
+			}
+		} else {
+			zap.S().Infow(loggerEventNotSubscribed, "order_id", order.Id, "event", event)
+		}
+
+		// the project doesn't expect the notification, so the order isn't left waiting for its delivery
+		n.result = metrics.ResultSkipped
+		n.completeOrder()
+		n.setNotificationHandled(statKey, statField, ps)
+		return nil
 	}
 
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
-		if n.order.PrivateStatus == recurringpb.OrderStatusPaymentSystemComplete {
-			order.PrivateStatus = recurringpb.OrderStatusProjectComplete
+	req, err := n.getPaymentNotification(event)
+	if err != nil {
+		n.HandleError(loggerErrorNotificationMalformed, err, nil)
+		return errors.New(loggerErrorNotificationMalformed)
+	}
+
+	var (
+		failed, retired, rejected int
+		// error of the deliveries which need the retry, the deferral is replaced by the failure of another endpoint
+		retryErr error
+	)
+
+	n.endpointResults = nil
+
+	for _, endpoint := range endpoints {
+		field := fmt.Sprintf(psNotificationsEndpointFieldMask, statField, endpoint.id)
+		rejectedField := fmt.Sprintf(psNotificationsRejectedFieldMask, field)
+
+		// don't send notification to the endpoint which already received it
+		if stat.Get(field) == true && !n.resend {
+			if stat.Get(rejectedField) {
+				rejected++
+			}
+			continue
 		}
-	} else {
-		zap.S().Errorw(errorNotSuccessStatus, "status", resp.StatusCode, "retry_count", n.RetryCount, "order.uuid", n.order.Uuid)
-		if n.RetryCount < RetryMaxCount {
-			return n.handleErrorWithRetry(loggerErrorNotificationRetry, errors.New(errorNotSuccessStatus), nil)
+
+		n.endpointRetired = false
+		attempts := len(n.attempts)
+		resp, sendErr := n.sendRequest(endpoint, req, NotificationActionPayment)
+
+		if sendErr != nil {
+			n.HandleError(loggerErrorNotificationRetry, sendErr, Table{"url": endpoint.url})
+			n.addEndpointResult(endpoint.url, attempts, sendErr)
+
+			if retryErr == nil || isDeliveryDeferred(retryErr) {
+				retryErr = sendErr
+			}
+
+			if n.endpointRetired {
+				retired++
+			} else {
+				failed++
+			}
+			continue
+		}
+
+		if n.classifyResponse(resp) != config.ResponseResultSuccess {
+			zap.S().Errorw(errorNotSuccessStatus, "status", resp.StatusCode, "retry_count", n.RetryCount,
+				"order.uuid", n.order.Uuid, "url", endpoint.url)
+			n.lastError = errors.New(errorNotSuccessStatus)
+			n.addEndpointResult(endpoint.url, attempts, n.lastError)
+			rejected++
+
+			if err := n.setStat(statKey, rejectedField, true); err != nil {
+				n.HandleError(LoggerNotificationRedis, err, nil)
+			}
+		} else {
+			n.addEndpointResult(endpoint.url, attempts, nil)
+
+			// the endpoint accepted the resent notification it rejected before
+			if stat.Get(rejectedField) {
+				if err := n.setStat(statKey, rejectedField, false); err != nil {
+					n.HandleError(LoggerNotificationRedis, err, nil)
+				}
+			}
+		}
+
+		if err := n.setStat(statKey, field, true); err != nil {
+			n.HandleError(LoggerNotificationRedis, err, nil)
 		}
+	}
+
+	// notifications to the retired endpoints are parked only when other endpoints don't need retries
+	n.endpointRetired = failed == 0 && retired > 0
+
+	if failed > 0 || retired > 0 {
+		// rejection by another endpoint mustn't hide the deferral, it isn't counted as the retry
+		n.lastError = retryErr
+		return n.retry()
+	}
+
+	// the order is rejected only when every endpoint the event targets rejected it, including endpoints
+	// which responded to the previous tries
+	if rejected == len(endpoints) {
+		n.result = metrics.ResultRejected
 		order.PrivateStatus = recurringpb.OrderStatusProjectReject
+	} else {
+		n.completeOrder()
 	}
 
-	err = n.setStat(statKey, ps, true)
-	if err != nil {
+	n.setNotificationHandled(statKey, statField, ps)
+
+	return nil
+}
+
+// completeOrder sets the project complete status of the order completed by the payment system
+func (n *Default) completeOrder() {
+	if n.order.PrivateStatus == recurringpb.OrderStatusPaymentSystemComplete {
+		n.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
+	}
+}
+
+// setNotificationHandled marks the notification for the order public status as sent
+func (n *Default) setNotificationHandled(statKey, statField, ps string) {
+	if err := n.setStat(statKey, statField, true); err != nil {
 		n.HandleError(LoggerNotificationRedis, err, nil)
 	}
 
-	order.SetNotificationStatus(ps, true)
-	if _, err := n.repository.UpdateOrder(context.TODO(), order); err != nil {
+	n.order.SetNotificationStatus(ps, true)
+	if err := n.updateOrder(n.order); err != nil {
 		n.HandleError(loggerErrorNotificationUpdate, err, nil)
 	}
-
-	return nil
 }
 
-func (n *Default) sendRequest(url string, req interface{}, action string) (*http.Response, error) {
-	reqUrl, err := n.validateUrl(url)
+func (n *Default) sendRequest(
+	endpoint *notificationEndpoint,
+	req *OrderNotificationMessage,
+	action string,
+) (*http.Response, error) {
+	reqUrl, err := n.validateUrl(endpoint.url)
 
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := json.Marshal(req)
+	encode, ok := messageEncoders[n.order.GetProject().GetCallbackProtocol()]
 
-	if err != nil {
-		return nil, err
+	if !ok {
+		encode = (*Default).encodeMessage
 	}
 
-	headers := map[string]string{
-		HeaderContentType:   MIMEApplicationJSON,
-		HeaderAccept:        MIMEApplicationJSON,
-		HeaderAuthorization: "Signature " + n.getSignature(b),
+	b, headers, err := encode(n, endpoint, req, time.Now())
+
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := n.request(http.MethodPost, reqUrl.String(), b, headers)
@@ -154,21 +385,50 @@ func (n *Default) sendRequest(url string, req interface{}, action string) (*http
 		return nil, err
 	}
 
-	oId := n.order.GetId()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent &&
-		resp.StatusCode != http.StatusUnprocessableEntity {
-		return nil, errors.New(fmt.Sprintf(errorNotificationNeedRetry, oId, action))
+	if n.classifyResponse(resp) == config.ResponseResultRetry {
+		return nil, errors.New(fmt.Sprintf(errorNotificationNeedRetry, n.order.GetId(), action))
 	}
 
 	return resp, nil
 }
 
-func (n *Default) getPaymentNotification() (*OrderNotificationMessage, error) {
+// encodeMessage returns json of the message in the encoding of the payload version and the headers
+// with the signature of the default protocol
+func (n *Default) encodeMessage(
+	endpoint *notificationEndpoint,
+	msg *OrderNotificationMessage,
+	now time.Time,
+) ([]byte, map[string]string, error) {
+	b, err := n.marshalPayload(msg)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := map[string]string{
+		HeaderContentType: MIMEApplicationJSON,
+		HeaderAccept:      MIMEApplicationJSON,
+	}
+	n.setSignatureHeaders(headers, b, endpoint.secretKey, now)
+
+	return b, headers, nil
+}
+
+// getEventName returns name of the notification event: the event of the manual resend, the event
+// of the catalogue for projects pinned to it or the event of the order public status
+func (n *Default) getEventName(stat *NotificationStat) string {
+	if n.resendEvent != "" {
+		return n.resendEvent
+	}
 
-	ps := n.order.GetPublicStatus()
+	if n.isEventCatalogue() {
+		return n.getCatalogueEventName(stat)
+	}
 
-	event := n.getNotificationEventName(ps)
+	return n.getNotificationEventName(n.order.GetPublicStatus())
+}
+
+func (n *Default) getPaymentNotification(event string) (*OrderNotificationMessage, error) {
 	if event == "" {
 		return nil, errors.New(errorNoEventForCurrentStatus)
 	}
@@ -178,28 +438,148 @@ func (n *Default) getPaymentNotification() (*OrderNotificationMessage, error) {
 
 	res := &OrderNotificationMessage{
 		Id:          hex.EncodeToString(h.Sum(nil)),
+		ApiVersion:  n.getPayloadVersion(),
 		Type:        "notification",
 		Event:       event,
-		CreatedAt:   time.Now().Format(time.RFC3339),
+		CreatedAt:   n.formatPayloadTime(time.Now()),
 		DeliveryTry: n.RetryCount,
-		Object:      n.order,
 	}
 
 	res.Live = n.order.Project.Status == billingpb.ProjectStatusInProduction
 
+	if expiresAt := n.getExpiresAt(); !expiresAt.IsZero() {
+		res.ExpiresAt = n.formatPayloadTime(expiresAt)
+	}
+
+	if event == eventNameDeclined {
+		res.Decline = n.getDecline()
+	}
+
+	// ping checks the endpoint and the signature only, so it has no order
+	if event == eventNamePing {
+		return res, nil
+	}
+
+	if n.getPayloadMode() == config.PayloadModeThin {
+		res.OrderId = n.order.GetId()
+		res.ProjectOrderId = n.order.GetProjectOrderId()
+		res.FetchUrl, res.FetchExpiresAt = n.getPayloadFetchUrl(time.Now())
+
+		return res, nil
+	}
+
+	object, err := n.GetOrderPayload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Object = object
+
 	return res, nil
 }
 
-func (n *Default) getSignature(req []byte) string {
+// getNotificationData returns data of the message for protocols with own envelope: the order object,
+// or the reference to the order in the thin payload mode, with the decline details of the declined payment
+func getNotificationData(msg *OrderNotificationMessage) map[string]interface{} {
+	data := make(map[string]interface{}, len(msg.Object))
+
+	for k, v := range msg.Object {
+		data[k] = v
+	}
+
+	refs := map[string]string{
+		"order_id":         msg.OrderId,
+		"project_order_id": msg.ProjectOrderId,
+		"fetch_url":        msg.FetchUrl,
+		"fetch_expires_at": msg.FetchExpiresAt,
+	}
+
+	for k, v := range refs {
+		if v != "" {
+			data[k] = v
+		}
+	}
+
+	if msg.Decline != nil {
+		data["decline"] = msg.Decline
+	}
+
+	return data
+}
+
+func (n *Default) getSignature(req []byte, secretKey string) string {
 	h := sha256.New()
-	h.Write([]byte(string(req) + n.order.GetProject().GetSecretKey()))
+	h.Write([]byte(string(req) + secretKey))
 
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func (n *Default) getNotificationUrl(_ string) string {
-	//INFO According #192488 we need to use just one webhook URL for all kind of notifications.
-	return n.order.Project.UrlProcessPayment
+// getHmacSignature returns HMAC-SHA256 of the "timestamp.body" string signed with the endpoint secret key
+func (n *Default) getHmacSignature(timestamp string, req []byte, secretKey string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(req)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Default) getSignatureScheme() string {
+	if scheme := n.cfg.GetProject(n.order.GetProject().GetId()).SignatureScheme; scheme != "" {
+		return scheme
+	}
+
+	if n.cfg != nil && n.cfg.SignatureScheme != "" {
+		return n.cfg.SignatureScheme
+	}
+
+	return SignatureSchemeLegacy
+}
+
+func (n *Default) setSignatureHeaders(headers map[string]string, req []byte, secretKey string, now time.Time) {
+	if n.getSignatureScheme() == SignatureSchemeLegacy {
+		headers[HeaderAuthorization] = "Signature " + n.getSignature(req, secretKey)
+		return
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	headers[HeaderPaySuperTimestamp] = ts
+	headers[HeaderPaySuperSignature] = signatureVersionV1 + "=" + n.getHmacSignature(ts, req, secretKey)
+}
+
+// getNotificationEndpoints returns endpoints of the project which accept the event, the ping is accepted by all
+// endpoints. Without configured endpoints the project url is used for all events. Returns nil if the project
+// has no url at all.
+func (n *Default) getNotificationEndpoints(event string) []*notificationEndpoint {
+	project := n.order.GetProject()
+	endpoints := n.cfg.GetProject(project.GetId()).Endpoints
+
+	if len(endpoints) == 0 {
+		//INFO According #192488 we need to use just one webhook URL for all kind of notifications.
+		if project.GetUrlProcessPayment() == "" {
+			return nil
+		}
+
+		return []*notificationEndpoint{newNotificationEndpoint(project.GetUrlProcessPayment(), project.GetSecretKey())}
+	}
+
+	res := make([]*notificationEndpoint, 0, len(endpoints))
+
+	for _, e := range endpoints {
+		if e == nil || (event != eventNamePing && !e.Accepts(event)) {
+			continue
+		}
+
+		secretKey := e.SecretKey
+
+		if secretKey == "" {
+			secretKey = project.GetSecretKey()
+		}
+
+		res = append(res, newNotificationEndpoint(e.Url, secretKey))
+	}
+
+	return res
 }
 
 func (n *Default) getNotificationEventName(publicStatus string) string {
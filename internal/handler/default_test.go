@@ -1,16 +1,21 @@
 package handler
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/jarcoal/httpmock"
 	"github.com/paysuper/paysuper-proto/go/billingpb"
 	billMocks "github.com/paysuper/paysuper-proto/go/billingpb/mocks"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/mock"
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +31,7 @@ var (
 	cancelUrl     = "http://localhost/cancel"
 	refundUrl     = "http://localhost/refund"
 
-	dummySignature = "10e4a4e3432c0dbb49f656cc3621c1d55fafb66149d185995e3affc18047d01a"
+	dummySignature = "e29e699463ebc546ec9da453aeb6ff81182a5b908a317d19b2c47231f33b87af"
 )
 
 type DefaultHandlerTestSuite struct {
@@ -144,12 +149,13 @@ func (suite *DefaultHandlerTestSuite) SetupTest() {
 		redis:      suite.redis,
 		cfg:        cfg,
 		dlv:        amqp.Delivery{RoutingKey: "*"},
+		httpClient: http.DefaultClient,
 	}
 
 	suite.handler.centrifugoPaymentForm = NewCentrifugo(cfg.CentrifugoPaymentForm, mock.NewCentrifugoTransportStatusOk())
 	suite.handler.centrifugoDashboard = NewCentrifugo(cfg.CentrifugoDashboard, mock.NewCentrifugoTransportStatusOk())
 
-	suite.handler.retBrok = mock.NewBrokerMockOk()
+	suite.handler.retryBrokers = RetryBrokers{RetryDlxTimeout: mock.NewBrokerMockOk()}
 	suite.handler.RetryCount = RetryMaxCount - 1
 
 	suite.defaultHandler = newDefaultHandler(suite.handler)
@@ -188,6 +194,7 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_Ok() {
 	assert.Equal(suite.T(), info["POST "+processUrl], 1)
 
 	assert.Equal(suite.T(), suite.handler.order.PrivateStatus, int32(recurringpb.OrderStatusProjectComplete))
+	assert.Equal(suite.T(), metrics.ResultDelivered, suite.handler.GetResult(err))
 
 	ps = suite.handler.order.GetPublicStatus()
 	assert.Equal(suite.T(), ps, recurringpb.OrderPublicStatusProcessed)
@@ -211,18 +218,14 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_Rejected() {
 
 	err := suite.defaultHandler.Notify()
 	assert.NoError(suite.T(), err)
-	assert.True(suite.T(), suite.handler.retryProcess)
+	assert.False(suite.T(), suite.handler.retryProcess)
 
 	info := httpmock.GetCallCountInfo()
 	assert.Equal(suite.T(), len(info), 1)
 	assert.Equal(suite.T(), info["POST "+processUrl], 1)
-	assert.Equal(suite.T(), suite.handler.order.PrivateStatus, int32(recurringpb.OrderStatusPaymentSystemComplete))
-
-	suite.handler.RetryCount = RetryMaxCount
-	err = suite.defaultHandler.Notify()
-	assert.NoError(suite.T(), err)
 
 	assert.Equal(suite.T(), suite.handler.order.PrivateStatus, int32(recurringpb.OrderStatusProjectReject))
+	assert.Equal(suite.T(), metrics.ResultRejected, suite.handler.GetResult(err))
 
 	assert.Equal(suite.T(), suite.handler.order.GetPublicStatus(), recurringpb.OrderPublicStatusRejected)
 
@@ -273,6 +276,7 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_AlreadySent_Ok()
 	info := httpmock.GetCallCountInfo()
 	assert.Equal(suite.T(), len(info), 1)
 	assert.Equal(suite.T(), info["POST "+processUrl], 0)
+	assert.Equal(suite.T(), metrics.ResultSkipped, suite.handler.GetResult(err))
 
 	nS = suite.handler.order.GetNotificationStatus(ps)
 	assert.True(suite.T(), nS)
@@ -333,33 +337,384 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getNotificationEventNam
 	assert.Equal(suite.T(), en, orderPublicStatusToEventNameMapping[ps])
 }
 
-func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getNotificationUrl() {
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getNotificationEndpoints() {
 	defaultHandler := &Default{}
 	defaultHandler.Handler = suite.handler
 
+	for _, event := range []string{eventNameSuccess, eventNameChargeback, eventNameCancel, eventNameRefund} {
+		endpoints := defaultHandler.getNotificationEndpoints(event)
+		assert.Len(suite.T(), endpoints, 1)
+		assert.Equal(suite.T(), processUrl, endpoints[0].url)
+		assert.Equal(suite.T(), suite.handler.order.Project.SecretKey, endpoints[0].secretKey)
+	}
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{
+				{Url: processUrl, Events: []string{eventNameSuccess}},
+				{Url: refundUrl, Events: []string{eventNameRefund, eventNameChargeback}, SecretKey: "Refund Secret"},
+				{Url: cancelUrl, Events: []string{"payment.*"}},
+			},
+		},
+	}
+
+	endpoints := defaultHandler.getNotificationEndpoints(eventNameSuccess)
+	assert.Len(suite.T(), endpoints, 2)
+	assert.Equal(suite.T(), processUrl, endpoints[0].url)
+	assert.Equal(suite.T(), cancelUrl, endpoints[1].url)
+
+	endpoints = defaultHandler.getNotificationEndpoints(eventNameRefund)
+	assert.Len(suite.T(), endpoints, 2)
+	assert.Equal(suite.T(), refundUrl, endpoints[0].url)
+	assert.Equal(suite.T(), "Refund Secret", endpoints[0].secretKey)
+	assert.NotEqual(suite.T(), endpoints[0].id, endpoints[1].id)
+
+	assert.Len(suite.T(), defaultHandler.getNotificationEndpoints("refund.partial"), 0)
+
+	suite.handler.cfg.Projects = nil
+	suite.handler.order.Project.UrlProcessPayment = ""
+	assert.Nil(suite.T(), defaultHandler.getNotificationEndpoints(eventNameSuccess))
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_FanOut() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{
+				{Url: processUrl},
+				{Url: refundUrl, Events: []string{eventNameSuccess}},
+				{Url: cancelUrl, Events: []string{eventNameCancel}},
+			},
+		},
+	}
+	suite.handler.RetryCount = 0
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(suite.T(), 1, info["POST "+processUrl])
+	assert.Equal(suite.T(), 1, info["POST "+refundUrl])
+	assert.Equal(suite.T(), 0, info["POST "+cancelUrl])
+
 	ps := suite.handler.order.GetPublicStatus()
-	assert.Equal(suite.T(), ps, recurringpb.OrderPublicStatusProcessed)
-	assert.Equal(suite.T(), ps, "processed")
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), stat.Get(ps))
+	assert.True(suite.T(), stat.Get(fmt.Sprintf(psNotificationsEndpointFieldMask, ps, GetEndpointId(processUrl))))
+	assert.False(suite.T(), stat.Get(fmt.Sprintf(psNotificationsEndpointFieldMask, ps, GetEndpointId(refundUrl))))
 
-	en := defaultHandler.getNotificationUrl(ps)
-	assert.NotEmpty(suite.T(), en)
-	assert.Equal(suite.T(), en, suite.handler.order.Project.UrlProcessPayment)
-	assert.Equal(suite.T(), en, processUrl)
+	// the retry is delivered only to the failed endpoint
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	suite.handler.retryProcess = false
+	suite.handler.lastError = nil
 
-	en = defaultHandler.getNotificationUrl("chargeback")
-	assert.NotEmpty(suite.T(), en)
-	assert.Equal(suite.T(), en, suite.handler.order.Project.UrlProcessPayment)
-	assert.Equal(suite.T(), en, processUrl)
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
 
-	en = defaultHandler.getNotificationUrl("canceled")
-	assert.NotEmpty(suite.T(), en)
-	assert.Equal(suite.T(), en, suite.handler.order.Project.UrlProcessPayment)
-	assert.Equal(suite.T(), en, processUrl)
+	info = httpmock.GetCallCountInfo()
+	assert.Equal(suite.T(), 1, info["POST "+processUrl])
+	assert.Equal(suite.T(), 2, info["POST "+refundUrl])
 
-	en = defaultHandler.getNotificationUrl("refunded")
-	assert.NotEmpty(suite.T(), en)
-	assert.Equal(suite.T(), en, suite.handler.order.Project.UrlProcessPayment)
-	assert.Equal(suite.T(), en, processUrl)
+	stat, err = suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), stat.Get(ps))
+
+	assert.NoError(suite.T(), ResetNotificationStat(suite.redis, suite.handler.order))
+
+	stat, err = suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), stat.Get(ps))
+	assert.True(suite.T(), stat.Get(fmt.Sprintf(psNotificationsEndpointFieldMask, ps, GetEndpointId(processUrl))))
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_ResetNotificationStat_Parked() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+	httpmock.RegisterResponder("POST", cancelUrl, httpmock.NewStringResponder(http.StatusUnprocessableEntity, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{{Url: processUrl}, {Url: refundUrl}, {Url: cancelUrl}},
+		},
+	}
+
+	parking := mock.NewParkingMockOk()
+	suite.handler.parking = parking
+	suite.handler.retryPolicy = &config.RetryPolicy{MaxCount: 1}
+	suite.handler.RetryCount = 1
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Len(suite.T(), parking.Parked, 1)
+	assert.Equal(suite.T(), metrics.ResultParked, suite.handler.GetResult(err))
+
+	// the replay is sent only to the endpoint which didn't receive the notification
+	assert.NoError(suite.T(), ResetNotificationStat(suite.redis, suite.handler.order))
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	suite.handler.RetryCount = 0
+	suite.handler.lastError = nil
+	suite.handler.result = ""
+
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(suite.T(), 1, info["POST "+processUrl])
+	assert.Equal(suite.T(), 1, info["POST "+cancelUrl])
+	assert.Equal(suite.T(), 2, info["POST "+refundUrl])
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+
+	ps := suite.handler.order.GetPublicStatus()
+	field := fmt.Sprintf(psNotificationsEndpointFieldMask, ps, GetEndpointId(cancelUrl))
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), stat.Get(ps))
+	assert.True(suite.T(), stat.Get(fmt.Sprintf(psNotificationsRejectedFieldMask, field)))
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_FanOut_Rejected() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusUnprocessableEntity, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{{Url: processUrl}, {Url: refundUrl}},
+		},
+	}
+	suite.handler.RetryCount = 0
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+
+	ps := suite.handler.order.GetPublicStatus()
+	field := fmt.Sprintf(psNotificationsEndpointFieldMask, ps, GetEndpointId(refundUrl))
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), stat.Get(field))
+	assert.True(suite.T(), stat.Get(fmt.Sprintf(psNotificationsRejectedFieldMask, field)))
+
+	// the order isn't rejected while another endpoint accepts the notification
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	suite.handler.retryProcess = false
+	suite.handler.lastError = nil
+
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+refundUrl])
+
+	// the rejection of the previous try is counted when the last endpoint rejects the notification too
+	assert.NoError(suite.T(), suite.redis.FlushDB().Err())
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusPaymentSystemComplete
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusUnprocessableEntity, ""))
+	suite.handler.retryProcess = false
+	suite.handler.lastError = nil
+
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectReject), suite.handler.order.PrivateStatus)
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_FanOut_DeferredNotCounted() {
+	throttledUrl := "http://throttled.localhost/process"
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", throttledUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusUnprocessableEntity, ""))
+
+	suite.handler.cfg.RateLimitGlobal = 0
+	suite.handler.cfg.RateLimitProject = 0
+	suite.handler.cfg.RateLimitHost = 0.001
+	suite.handler.cfg.RateLimitHostBurst = 1
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{{Url: throttledUrl}, {Url: processUrl}},
+		},
+	}
+	suite.handler.RetryCount = 0
+	suite.handler.retryDeferred = 0
+	assert.NoError(suite.T(), suite.handler.rateLimit(throttledUrl))
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+	assert.True(suite.T(), errors.Is(suite.handler.lastError, ErrRateLimited))
+	assert.True(suite.T(), suite.handler.retryDeferred > 0)
+	assert.Equal(suite.T(), metrics.ResultDeferred, suite.handler.GetResult(err))
+	assert.Equal(suite.T(), 0, httpmock.GetCallCountInfo()["POST "+throttledUrl])
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_NotSubscribed() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {Events: []string{eventNameRefund, "chargeback.*"}},
+	}
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), 0, httpmock.GetTotalCallCount())
+
+	ps := suite.handler.order.GetPublicStatus()
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), stat.Get(ps))
+	suite.handler.repository.(*billMocks.BillingService).AssertNumberOfCalls(suite.T(), "UpdateOrder", 1)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+
+	err = suite.handler.SetResend("")
+	assert.NoError(suite.T(), err)
+	assert.EqualError(suite.T(), suite.defaultHandler.Notify(), errorEventNotSubscribed)
+	assert.Equal(suite.T(), 0, httpmock.GetTotalCallCount())
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_NoEndpointAccepts() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{
+				{Url: processUrl, Events: []string{eventNameCancel}},
+				{Url: refundUrl, Events: []string{eventNameRefund}},
+			},
+		},
+	}
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), 0, httpmock.GetTotalCallCount())
+
+	ps := suite.handler.order.GetPublicStatus()
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), stat.Get(ps))
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+	suite.handler.repository.(*billMocks.BillingService).AssertNumberOfCalls(suite.T(), "UpdateOrder", 1)
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Notify_PartialRefunds() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.cfg.PayloadVersion = config.PayloadVersionV3
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusRefund
+	suite.handler.order.RefundedAt = &timestamp.Timestamp{Seconds: 1580000000}
+	suite.handler.order.Refund = &billingpb.OrderNotificationRefund{Amount: 3, Currency: "RUB"}
+
+	err := suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+
+	// the same refund isn't sent again
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+
+	// the second partial refund of the order is a new event
+	suite.handler.order.RefundedAt = &timestamp.Timestamp{Seconds: 1580000100}
+	suite.handler.order.Refund = &billingpb.OrderNotificationRefund{Amount: 3, Currency: "RUB"}
+
+	err = suite.defaultHandler.Notify()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), 2, httpmock.GetCallCountInfo()["POST "+processUrl])
+
+	ps := suite.handler.order.GetPublicStatus()
+	stat, err := suite.handler.getStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id))
+	assert.NoError(suite.T(), err)
+
+	for _, id := range []string{"1580000000.000000000-3", "1580000100.000000000-3"} {
+		assert.True(suite.T(), stat.Get(fmt.Sprintf(psNotificationsRefundFieldMask, ps, eventNameRefundPartial, id)))
+	}
+
+	suite.handler.cfg.PayloadVersion = config.PayloadVersionV1
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getEventName_Catalogue() {
+	defaultHandler := &Default{}
+	defaultHandler.Handler = suite.handler
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusNew
+	stat := &NotificationStat{data: map[string]string{}}
+	assert.Empty(suite.T(), defaultHandler.getEventName(stat))
+
+	suite.handler.cfg.PayloadVersion = config.PayloadVersionV3
+
+	assert.Equal(suite.T(), eventNameCreated, defaultHandler.getEventName(stat))
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusPaymentSystemCreate
+	assert.Equal(suite.T(), eventNamePending, defaultHandler.getEventName(stat))
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusPaymentSystemDeclined
+	assert.Equal(suite.T(), eventNameDeclined, defaultHandler.getEventName(stat))
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusChargeback
+	assert.Equal(suite.T(), eventNameChargebackOpened, defaultHandler.getEventName(stat))
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
+	assert.Equal(suite.T(), eventNameSuccess, defaultHandler.getEventName(stat))
+
+	stat.data[fmt.Sprintf(psNotificationsEventFieldMask, recurringpb.OrderPublicStatusChargeback, eventNameChargebackOpened)] = "1"
+	assert.Equal(suite.T(), eventNameChargebackResolved, defaultHandler.getEventName(stat))
+
+	ps := suite.handler.order.GetPublicStatus()
+	assert.Equal(suite.T(), ps+":"+eventNameChargebackResolved, defaultHandler.getStatField(ps, eventNameChargebackResolved))
+
+	suite.handler.cfg.PayloadVersion = config.PayloadVersionV1
+	assert.Equal(suite.T(), eventNameSuccess, defaultHandler.getEventName(stat))
+	assert.Equal(suite.T(), ps, defaultHandler.getStatField(ps, eventNameSuccess))
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getPaymentNotification_Declined() {
+	defaultHandler := &Default{}
+	defaultHandler.Handler = suite.handler
+
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusPaymentSystemDeclined
+	suite.handler.order.PaymentMethodTxnParams[billingpb.TxnParamsFieldDeclineCode] = "11"
+	suite.handler.order.PaymentMethodTxnParams[billingpb.TxnParamsFieldDeclineReason] = "Some reason"
+
+	msg, err := defaultHandler.getPaymentNotification(eventNameDeclined)
+	assert.NoError(suite.T(), err)
+	assert.NotNil(suite.T(), msg.Decline)
+	assert.Equal(suite.T(), suite.handler.order.GetPublicDeclineCode(), msg.Decline.Code)
+	assert.Equal(suite.T(), suite.handler.order.GetDeclineReason(), msg.Decline.Reason)
+
+	msg, err = defaultHandler.getPaymentNotification(eventNameCancel)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), msg.Decline)
 }
 
 func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getSignature() {
@@ -370,7 +725,7 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getSignature() {
 	b, err := json.Marshal(req)
 	assert.NoError(suite.T(), err)
 
-	s := defaultHandler.getSignature(b)
+	s := defaultHandler.getSignature(b, suite.handler.order.Project.SecretKey)
 	assert.Equal(suite.T(), s, dummySignature)
 }
 
@@ -384,9 +739,70 @@ func (suite *DefaultHandlerTestSuite) TestDefaultHandler_sendRequest_signatureCh
 		return httpmock.NewStringResponse(http.StatusOK, ""), nil
 	})
 
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {SignatureScheme: SignatureSchemeLegacy},
+	}
+
+	defaultHandler := &Default{}
+	defaultHandler.Handler = suite.handler
+
+	_, err := defaultHandler.sendRequest(
+		newNotificationEndpoint(processUrl, suite.handler.order.Project.SecretKey),
+		&OrderNotificationMessage{},
+		"",
+	)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_sendRequest_hmacSignatureCheck_Ok() {
+	b, err := json.Marshal(&OrderNotificationMessage{})
+	assert.NoError(suite.T(), err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		ts := req.Header.Get(HeaderPaySuperTimestamp)
+		assert.NotEmpty(suite.T(), ts)
+		assert.Empty(suite.T(), req.Header.Get(HeaderAuthorization))
+
+		mac := hmac.New(sha256.New, []byte(suite.handler.order.Project.SecretKey))
+		mac.Write([]byte(ts + "." + string(b)))
+		assert.Equal(suite.T(), "v1="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get(HeaderPaySuperSignature))
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
 	defaultHandler := &Default{}
 	defaultHandler.Handler = suite.handler
 
-	_, err := defaultHandler.sendRequest(processUrl, &OrderNotificationMessage{}, "")
+	assert.Equal(suite.T(), SignatureSchemeLegacy, defaultHandler.getSignatureScheme())
+
+	suite.handler.cfg.SignatureScheme = SignatureSchemeHmacSha256
+	assert.Equal(suite.T(), SignatureSchemeHmacSha256, defaultHandler.getSignatureScheme())
+
+	_, err = defaultHandler.sendRequest(
+		newNotificationEndpoint(processUrl, suite.handler.order.Project.SecretKey),
+		&OrderNotificationMessage{},
+		"",
+	)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_Decode_InvalidSignatureScheme() {
+	projects := config.Projects{}
+	err := projects.Decode(`{"project": {"signature_scheme": "hmac-sha265"}}`)
+	assert.Error(suite.T(), err)
+
+	err = projects.Decode(`{"project": {"signature_scheme": "hmac-sha256"}}`)
 	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), SignatureSchemeHmacSha256, projects["project"].SignatureScheme)
+}
+
+func (suite *DefaultHandlerTestSuite) TestDefaultHandler_getHmacSignature() {
+	defaultHandler := &Default{}
+	defaultHandler.Handler = suite.handler
+
+	s1 := defaultHandler.getHmacSignature("1577836800", []byte("{}"), suite.handler.order.Project.SecretKey)
+	s2 := defaultHandler.getHmacSignature("1577836801", []byte("{}"), suite.handler.order.Project.SecretKey)
+	assert.Len(suite.T(), s1, 64)
+	assert.NotEqual(suite.T(), s1, s2)
 }
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"context"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
 )
 
@@ -19,7 +18,7 @@ func (n *Empty) Notify() error {
 	}
 
 	n.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
-	_, err := n.repository.UpdateOrder(context.TODO(), n.order)
+	err := n.updateOrder(n.order)
 
 	if err != nil {
 		return n.handleErrorWithRetry(loggerErrorNotificationUpdate, err, nil)
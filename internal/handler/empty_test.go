@@ -115,7 +115,7 @@ func (suite *EmptyHandlerTestSuite) SetupTest() {
 		repository: bs,
 	}
 
-	suite.handler.retBrok = mock.NewBrokerMockOk()
+	suite.handler.retryBrokers = RetryBrokers{RetryDlxTimeout: mock.NewBrokerMockOk()}
 	suite.handler.RetryCount = RetryMaxCount - 1
 
 	suite.emptyHandler = newEmptyHandler(suite.handler)
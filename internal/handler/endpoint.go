@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	endpointsRetiredKeyMask = "ps:endpoints:retired:%s"
+
+	LoggerEndpointRedis    = "Retired endpoint state in redis failed"
+	loggerEndpointRetired  = "Project endpoint responded 410 Gone and was retired"
+	loggerRetiredNoRetries = "Notification to retired project endpoint is not retried"
+
+	centrifugoMsgEndpointRetired = "notification url responded 410 Gone and was retired, notifications to it are parked"
+)
+
+// ErrEndpointRetired is returned instead of the http request to the endpoint which responded 410 Gone
+var ErrEndpointRetired = errors.New("project endpoint is retired")
+
+// IsEndpointRetired checks that the project endpoint is retired
+func IsEndpointRetired(rdb *redis.Client, projectId, url string) (bool, error) {
+	return rdb.HExists(fmt.Sprintf(endpointsRetiredKeyMask, projectId), url).Result()
+}
+
+// RestoreEndpoint removes the retired mark of the project endpoint, so notifications are sent to it again
+func RestoreEndpoint(rdb *redis.Client, projectId, url string) error {
+	return rdb.HDel(fmt.Sprintf(endpointsRetiredKeyMask, projectId), url).Err()
+}
+
+// checkEndpoint returns ErrEndpointRetired if the endpoint is retired.
+// Manual resend is sent to the retired endpoint too.
+func (h *Handler) checkEndpoint(url string) error {
+	if h.redis == nil || h.resend {
+		return nil
+	}
+
+	retired, err := IsEndpointRetired(h.redis, h.order.GetProject().GetId(), url)
+
+	if err != nil {
+		// endpoint state must not block deliveries when it is unavailable
+		h.HandleError(LoggerEndpointRedis, err, nil)
+		return nil
+	}
+
+	if retired {
+		h.endpointRetired = true
+		return ErrEndpointRetired
+	}
+
+	return nil
+}
+
+// retireEndpoint marks the endpoint as retired and notifies administrators.
+// Endpoint which responded 410 Gone to the test notification isn't retired.
+func (h *Handler) retireEndpoint(url string) {
+	if h.test {
+		return
+	}
+
+	h.endpointRetired = true
+	zap.S().Warnw(loggerEndpointRetired, "project_id", h.order.GetProject().GetId(), "url", url, "order_id", h.order.Id)
+
+	if h.redis != nil {
+		err := h.redis.HSet(fmt.Sprintf(endpointsRetiredKeyMask, h.order.GetProject().GetId()), url, time.Now().Unix()).Err()
+
+		if err != nil {
+			h.HandleError(LoggerEndpointRedis, err, nil)
+		}
+	}
+
+	if err := h.sendToAdminCentrifugo(h.order, centrifugoMsgEndpointRetired); err != nil {
+		h.HandleError(LoggerNotificationCentrifugo, err, nil)
+	}
+}
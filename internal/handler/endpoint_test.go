@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"errors"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+type EndpointTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_Endpoint(t *testing.T) {
+	suite.Run(t, new(EndpointTestSuite))
+}
+
+func (suite *EndpointTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerDefault)
+	suite.redis = suite.handler.redis
+}
+
+func (suite *EndpointTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *EndpointTestSuite) TestEndpoint_Gone_Retired() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusGone, ""))
+
+	rsp, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusGone, rsp.StatusCode)
+	assert.True(suite.T(), suite.handler.endpointRetired)
+
+	retired, err := IsEndpointRetired(suite.redis, suite.handler.order.Project.Id, processUrl)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), retired)
+
+	h := &Handler{order: suite.handler.order, redis: suite.redis, cfg: suite.handler.cfg, httpClient: http.DefaultClient}
+	_, err = h.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.True(suite.T(), errors.Is(err, ErrEndpointRetired))
+	assert.True(suite.T(), h.endpointRetired)
+	assert.Equal(suite.T(), 1, httpmock.GetTotalCallCount())
+}
+
+func (suite *EndpointTestSuite) TestEndpoint_Resend_Restored() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.retireEndpoint(processUrl)
+	suite.handler.endpointRetired = false
+	suite.handler.resend = true
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.endpointRetired)
+
+	retired, err := IsEndpointRetired(suite.redis, suite.handler.order.Project.Id, processUrl)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), retired)
+}
+
+func (suite *EndpointTestSuite) TestEndpoint_TooManyRequests_RetryAfter() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	rsp := httpmock.NewStringResponse(http.StatusTooManyRequests, "")
+	rsp.Header.Set(HeaderRetryAfter, "300")
+	httpmock.RegisterResponder("POST", processUrl, httpmock.ResponderFromResponse(rsp))
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int32(300), suite.handler.retryAfter)
+	assert.False(suite.T(), suite.handler.endpointRetired)
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+)
+
+const (
+	eventNameCreated            = "payment.created"
+	eventNamePending            = "payment.pending"
+	eventNameDeclined           = "payment.declined"
+	eventNameRefundPartial      = "refund.partial"
+	eventNameChargebackOpened   = "chargeback.opened"
+	eventNameChargebackResolved = "chargeback.resolved"
+
+	psNotificationsEventFieldMask = "%s:%s"
+	// Field of the notification stat marking the sent partial refund: "<public status>:<event>:<refund id>"
+	psNotificationsRefundFieldMask = "%s:%s:%s"
+)
+
+var (
+	// Events of the catalogue sent to projects pinned to the v3 or later payload by the order private status.
+	// Payment returned to the processed status after the chargeback notification is chargeback.resolved,
+	// refund of the amount less than the total payment amount is refund.partial.
+	orderPrivateStatusToCatalogueEventMapping = map[int32]string{
+		recurringpb.OrderStatusNew:                         eventNameCreated,
+		recurringpb.OrderStatusPaymentSystemCreate:         eventNamePending,
+		recurringpb.OrderStatusPaymentSystemRejectOnCreate: eventNameDeclined,
+		recurringpb.OrderStatusPaymentSystemReject:         eventNameDeclined,
+		recurringpb.OrderStatusPaymentSystemDeclined:       eventNameDeclined,
+		recurringpb.OrderStatusPaymentSystemComplete:       eventNameSuccess,
+		recurringpb.OrderStatusProjectInProgress:           eventNameSuccess,
+		recurringpb.OrderStatusProjectComplete:             eventNameSuccess,
+		recurringpb.OrderStatusProjectPending:              eventNameSuccess,
+		recurringpb.OrderStatusProjectReject:               eventNameCancel,
+		recurringpb.OrderStatusPaymentSystemCanceled:       eventNameCancel,
+		recurringpb.OrderStatusRefund:                      eventNameRefund,
+		recurringpb.OrderStatusChargeback:                  eventNameChargebackOpened,
+	}
+
+	// All events of the default protocol
+	eventNames = []string{
+		eventNameSuccess,
+		eventNameChargeback,
+		eventNameCancel,
+		eventNameRefund,
+		eventNameCreated,
+		eventNamePending,
+		eventNameDeclined,
+		eventNameRefundPartial,
+		eventNameChargebackOpened,
+		eventNameChargebackResolved,
+	}
+)
+
+// OrderNotificationDecline contains the reason of the declined payment
+type OrderNotificationDecline struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// isDefaultEventsProtocol checks that the callback protocol sends events of the default protocol
+func isDefaultEventsProtocol(protocol string) bool {
+	_, ok := messageEncoders[protocol]
+
+	return ok
+}
+
+func isKnownEventName(event string) bool {
+	for _, v := range eventNames {
+		if v == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isEventCatalogue checks that the project is pinned to the payload version with the expanded event catalogue
+func (h *Handler) isEventCatalogue() bool {
+	v := h.getPayloadVersion()
+
+	return v != config.PayloadVersionV1 && v != config.PayloadVersionV2
+}
+
+// getCatalogueEventName returns event of the catalogue by the order private status and the sent notifications
+func (h *Handler) getCatalogueEventName(stat *NotificationStat) string {
+	event := orderPrivateStatusToCatalogueEventMapping[h.order.GetPrivateStatus()]
+
+	switch event {
+	case eventNameSuccess:
+		chargeback := recurringpb.OrderPublicStatusChargeback
+		opened := fmt.Sprintf(psNotificationsEventFieldMask, chargeback, eventNameChargebackOpened)
+
+		if stat != nil && (stat.Get(chargeback) || stat.Get(opened)) {
+			return eventNameChargebackResolved
+		}
+	case eventNameRefund:
+		amount := h.order.GetRefund().GetAmount()
+
+		if amount > 0 && amount < h.order.GetTotalPaymentAmount() {
+			return eventNameRefundPartial
+		}
+	}
+
+	return event
+}
+
+// getStatField returns field of the notification stat marking the sent event. Several events of the catalogue
+// share the public status, so the event name is added to the field, and the refund for partial refunds.
+func (h *Handler) getStatField(ps, event string) string {
+	if !h.isEventCatalogue() {
+		return ps
+	}
+
+	// an order can be refunded partially several times, every refund is a separate event
+	if event == eventNameRefundPartial {
+		return fmt.Sprintf(psNotificationsRefundFieldMask, ps, event, h.getRefundId())
+	}
+
+	return fmt.Sprintf(psNotificationsEventFieldMask, ps, event)
+}
+
+// getRefundId returns identifier of the last refund of the order by its time and amount. It is never a valid
+// endpoint identifier, so the mark of the refund isn't taken for the mark of the endpoint.
+func (h *Handler) getRefundId() string {
+	t := h.order.GetRefundedAt()
+
+	return fmt.Sprintf("%d.%09d-%g", t.GetSeconds(), t.GetNanos(), h.order.GetRefund().GetAmount())
+}
+
+// getDecline returns reason of the declined payment
+func (h *Handler) getDecline() *OrderNotificationDecline {
+	if !h.order.IsDeclined() {
+		return nil
+	}
+
+	return &OrderNotificationDecline{
+		Code:   h.order.GetPublicDeclineCode(),
+		Reason: h.order.GetDeclineReason(),
+	}
+}
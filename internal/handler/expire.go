@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	psNotificationsExpiredKeyMask = "ps:notify:expired:%s"
+
+	loggerNotificationExpired        = "Notification expired and will not be delivered"
+	centrifugoMsgNotificationExpired = "notification expired"
+)
+
+// GetExpiredNotifications returns unix times when notifications of the order expired keyed by the public status
+func GetExpiredNotifications(rdb *redis.Client, orderId string) (map[string]string, error) {
+	return rdb.HGetAll(fmt.Sprintf(psNotificationsExpiredKeyMask, orderId)).Result()
+}
+
+// getFirstAttemptAt returns unix time of the first delivery try of the notification
+func (h *Handler) getFirstAttemptAt() int64 {
+	if h.retryFirstAttemptAt == 0 {
+		h.retryFirstAttemptAt = time.Now().Unix()
+	}
+
+	return h.retryFirstAttemptAt
+}
+
+// getEventProducedAt returns unix time the event of the notification was produced: the last update of the order,
+// or the replay of the parked notification which starts the new lifetime. Without update time of the order
+// the first delivery try is used.
+func (h *Handler) getEventProducedAt() int64 {
+	producedAt := h.order.GetUpdatedAt().GetSeconds()
+
+	if producedAt <= 0 {
+		producedAt = h.getFirstAttemptAt()
+	}
+
+	if replayedAt := getInt64Header(h.dlv, replayedAtHeader); replayedAt > producedAt {
+		producedAt = replayedAt
+	}
+
+	return producedAt
+}
+
+// getExpiresAt returns time after which the notification is not delivered, zero time if it never expires.
+// Lifetime of the event is counted from the time the event was produced, so the notification of the order
+// update which stayed in the queue expires in time. Time the delivery was deferred by the circuit breaker
+// or the rate limits extends the lifetime.
+func (h *Handler) getExpiresAt() time.Time {
+	ttl := h.cfg.GetNotificationTtl(h.order.GetProject().GetId())
+
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(h.getEventProducedAt()+ttl+h.retryDeferred, 0)
+}
+
+// isExpired checks that lifetime of the notification passed. Manual resend never expires.
+func (h *Handler) isExpired(now time.Time) bool {
+	if h.resend {
+		return false
+	}
+
+	expiresAt := h.getExpiresAt()
+
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// expire records the notification for the current order status as expired and alerts admins instead of delivery
+func (h *Handler) expire() {
+	h.result = metrics.ResultExpired
+	metrics.NotificationsExpiredTotal.WithLabelValues(h.order.GetProject().GetCallbackProtocol()).Inc()
+	zap.S().Infow(
+		loggerNotificationExpired,
+		"order_id", h.order.GetId(),
+		"status", h.order.GetPublicStatus(),
+		"retry_count", h.RetryCount,
+	)
+
+	if h.redis != nil {
+		key := fmt.Sprintf(psNotificationsExpiredKeyMask, h.order.GetId())
+
+		if err := h.redis.HSet(key, h.order.GetPublicStatus(), time.Now().Unix()).Err(); err != nil {
+			h.HandleError(LoggerNotificationRedis, err, nil)
+		}
+	}
+
+	if h.centrifugoDashboard == nil {
+		return
+	}
+
+	if err := h.sendToAdminCentrifugo(h.order, centrifugoMsgNotificationExpired); err != nil {
+		h.HandleError(LoggerNotificationCentrifugo, err, nil)
+	}
+}
@@ -9,11 +9,13 @@ import (
 	"github.com/micro/protobuf/ptypes"
 	"github.com/paysuper/paysuper-proto/go/billingpb"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
-	httpTool "github.com/paysuper/paysuper-tools/http"
 	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 	rabbitmq "gopkg.in/ProtocolONE/rabbitmq.v1/pkg"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"time"
@@ -28,6 +30,12 @@ const (
 	notifierHandlerCardPay = "cardpay"
 	// Notification request send by XSolla  notification protocol
 	notifierHandlerXSolla = "xsolla"
+	// Notification request send by Standard Webhooks specification with events of PaySuper notification protocol
+	notifierHandlerStandardWebhooks = "standardwebhooks"
+	// Notification request send as CloudEvent with events of PaySuper notification protocol
+	notifierHandlerCloudEvents = "cloudevents"
+	// Notification request rendered by templates of the project with events of PaySuper notification protocol
+	notifierHandlerTemplate = "template"
 
 	errorNotifierHandlerNotFound               = "handler for specified payment system not found"
 	errorPaymentMethodUnknown                  = "unknown payment method"
@@ -35,6 +43,8 @@ const (
 	errorPaymentMethodUnknownStatus            = "unknown transaction status"
 	errorEmptyUrl                              = "empty string in url"
 	errorNotificationNeedRetry                 = "bad project handler response notification request mark for new send (ID: %s, Action: %s)\n"
+	errorRetryBrokerNotFound                   = "retry delay queue not found"
+	errorHttpClientNotSet                      = "http client for requests to project isn't set"
 
 	loggerErrorNotificationRetry       = "Project notification failed"
 	loggerErrorNotificationUpdate      = "Repository service return error. Update order failed"
@@ -50,6 +60,10 @@ const (
 	HeaderContentType   = "Content-Type"
 	HeaderSignature     = "Signature"
 	HeaderAuthorization = "Authorization"
+	HeaderRetryAfter    = "Retry-After"
+
+	HeaderPaySuperTimestamp = "X-PaySuper-Timestamp"
+	HeaderPaySuperSignature = "X-PaySuper-Signature"
 
 	NotificationActionCheck   = "check"
 	NotificationActionPayment = "payment"
@@ -64,6 +78,10 @@ const (
 	RetryMaxCount     = 288
 	retryCountHeader  = "x-retry-count"
 
+	retryFirstAttemptHeader = "x-retry-first-attempt-at"
+	retryNotBeforeHeader    = "x-retry-not-before"
+	retryDeferredHeader     = "x-retry-deferred"
+
 	taxjarNotificationsKeyMask = "tj:notify:%s"
 
 	CountryCodeUSA = "US"
@@ -71,10 +89,13 @@ const (
 
 var (
 	handlers = map[string]func(*Handler) Notifier{
-		notifierHandlerEmpty:   newEmptyHandler,
-		notifierHandlerDefault: newDefaultHandler,
-		notifierHandlerCardPay: newCardPayHandler,
-		notifierHandlerXSolla:  newXSollaHandler,
+		notifierHandlerEmpty:            newEmptyHandler,
+		notifierHandlerDefault:          newDefaultHandler,
+		notifierHandlerCardPay:          newCardPayHandler,
+		notifierHandlerXSolla:           newXSollaHandler,
+		notifierHandlerStandardWebhooks: newDefaultHandler,
+		notifierHandlerCloudEvents:      newDefaultHandler,
+		notifierHandlerTemplate:         newDefaultHandler,
 	}
 )
 
@@ -100,12 +121,28 @@ func (ns *NotificationStat) Get(key string) bool {
 type Handler struct {
 	order                    *billingpb.Order
 	repository               billingpb.BillingService
-	retBrok                  rabbitmq.BrokerInterface
+	retryBrokers             RetryBrokers
 	taxjarTransactionsBroker rabbitmq.BrokerInterface
 	taxjarRefundsBroker      rabbitmq.BrokerInterface
 	dlv                      amqp.Delivery
 	RetryCount               int32
 	retryProcess             bool
+	retryPolicy              *config.RetryPolicy
+	retryFirstAttemptAt      int64
+	retryDeferred            int64
+	retryHistory             []interface{}
+	retryAfter               int32
+	endpointRetired          bool
+	lastError                error
+	result                   string
+	parking                  ParkingInterface
+	httpClient               *http.Client
+	resend                   bool
+	resendEvent              string
+	test                     bool
+	lastAttempt              *DeliveryAttempt
+	attempts                 []*DeliveryAttempt
+	endpointResults          []*EndpointResult
 	redis                    *redis.Client
 	cfg                      *config.Config
 	centrifugoPaymentForm    CentrifugoInterface
@@ -115,9 +152,11 @@ type Handler struct {
 func NewHandler(
 	o *billingpb.Order,
 	rep billingpb.BillingService,
-	retBrok rabbitmq.BrokerInterface,
+	retryBrokers RetryBrokers,
 	taxjarTransactionsBroker rabbitmq.BrokerInterface,
 	taxjarRefundsBroker rabbitmq.BrokerInterface,
+	parking ParkingInterface,
+	httpClient *http.Client,
 	redis *redis.Client,
 	dlv amqp.Delivery,
 	cfg *config.Config,
@@ -130,15 +169,23 @@ func NewHandler(
 		rtc = v.(int32)
 	}
 
+	live := o.GetProject().GetStatus() == billingpb.ProjectStatusInProduction
+
 	return &Handler{
 		order:                    o,
 		repository:               rep,
-		retBrok:                  retBrok,
+		retryBrokers:             retryBrokers,
 		taxjarTransactionsBroker: taxjarTransactionsBroker,
 		taxjarRefundsBroker:      taxjarRefundsBroker,
+		parking:                  parking,
+		httpClient:               httpClient,
 		redis:                    redis,
 		dlv:                      dlv,
 		RetryCount:               rtc,
+		retryPolicy:              cfg.GetRetryPolicy(o.GetProject().GetId(), live),
+		retryFirstAttemptAt:      getRetryFirstAttemptAt(dlv),
+		retryDeferred:            getRetryDeferred(dlv),
+		retryHistory:             getRetryHistory(dlv),
 		cfg:                      cfg,
 		centrifugoPaymentForm:    centrifugoPaymentForm,
 		centrifugoDashboard:      centrifugoDashboard,
@@ -160,6 +207,11 @@ func (h *Handler) GetNotifier() (Notifier, error) {
 }
 
 func (h *Handler) trySendToTaxJar() {
+	// test notification of the sample order is never reported
+	if h.test {
+		return
+	}
+
 	order := h.order
 
 	ps := order.GetPublicStatus()
@@ -170,7 +222,7 @@ func (h *Handler) trySendToTaxJar() {
 	}
 
 	// Dont't send notification if the Tax rate is empty (see issue #190343)
-	if order.Tax.Rate == 0 {
+	if order.GetTax().GetRate() == 0 {
 		return
 	}
 
@@ -201,7 +253,7 @@ func (h *Handler) trySendToTaxJar() {
 
 	if stat.Get(tjStatus) == true {
 		order.SetNotificationStatus(taxjarStatusName, true)
-		if _, err := h.repository.UpdateOrder(context.TODO(), order); err != nil {
+		if err := h.updateOrder(order); err != nil {
 			h.HandleError(loggerErrorNotificationUpdate, err, nil)
 		}
 		return
@@ -209,6 +261,7 @@ func (h *Handler) trySendToTaxJar() {
 
 	publishErr := taxjarBroker.Publish(topicName, order, amqp.Table{"x-retry-count": int32(0)})
 	isSuccess := publishErr == nil
+	metrics.TaxjarPublishesTotal.WithLabelValues(tjStatus, metrics.GetResult(publishErr)).Inc()
 
 	err = h.setStat(stat.StatKey, tjStatus, isSuccess)
 	if err != nil {
@@ -216,7 +269,7 @@ func (h *Handler) trySendToTaxJar() {
 	}
 
 	order.SetNotificationStatus(taxjarStatusName, true)
-	if _, err := h.repository.UpdateOrder(context.TODO(), order); err != nil {
+	if err := h.updateOrder(order); err != nil {
 		h.HandleError(loggerErrorNotificationUpdate, err, nil)
 	}
 
@@ -237,11 +290,21 @@ func (h *Handler) validateUrl(cUrl string) (*url.URL, error) {
 		return nil, err
 	}
 
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New(errorUrlSchemeInvalid)
+	}
+
 	return u, nil
 }
 
 func (h *Handler) request(method, url string, req []byte, headers map[string]string) (*http.Response, error) {
-	client := httpTool.NewLoggedHttpClient(zap.S())
+	client := h.httpClient
+
+	// requests to projects are sent only by the client protected by the address guard
+	if client == nil {
+		return nil, errors.New(errorHttpClientNotSet)
+	}
+
 	httpReq, err := http.NewRequest(method, url, bytes.NewBuffer(req))
 
 	if err != nil {
@@ -252,7 +315,91 @@ func (h *Handler) request(method, url string, req []byte, headers map[string]str
 		httpReq.Header.Add(k, v)
 	}
 
-	return client.Do(httpReq)
+	protocol := h.order.GetProject().GetCallbackProtocol()
+	if err := h.checkEndpoint(url); err != nil {
+		return nil, err
+	}
+
+	cb := h.getBreaker(url)
+
+	if err := h.breakerAllow(cb); err != nil {
+		metrics.BreakerDeferredTotal.WithLabelValues(protocol).Inc()
+		return nil, err
+	}
+
+	if err := h.rateLimit(url); err != nil {
+		h.breakerRelease(cb)
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	latency := time.Since(start)
+	metrics.DeliveryDuration.WithLabelValues(protocol).Observe(latency.Seconds())
+	h.breakerResult(cb, resp, err)
+
+	attempt := h.newDeliveryAttempt(method, url, req, headers, latency)
+	h.lastAttempt = attempt
+	h.attempts = append(h.attempts, attempt)
+
+	if err != nil {
+		attempt.Error = err.Error()
+		h.saveDeliveryAttempt(attempt)
+		metrics.DeliveriesTotal.WithLabelValues(protocol, metrics.StatusClassError).Inc()
+
+		if errors.Is(err, ErrAddressForbidden) {
+			if err := h.sendToAdminCentrifugo(h.order, centrifugoMsgNotificationUrlForbidden); err != nil {
+				h.HandleError(LoggerNotificationCentrifugo, err, nil)
+			}
+		}
+
+		return nil, err
+	}
+
+	metrics.DeliveriesTotal.WithLabelValues(protocol, metrics.GetStatusClass(resp.StatusCode)).Inc()
+
+	rspBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, responseBodyMaxLength))
+	_ = resp.Body.Close()
+
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewBuffer(rspBody))
+	attempt.ResponseStatus = resp.StatusCode
+	attempt.ResponseBody = h.truncateAttemptBody(rspBody)
+	h.saveDeliveryAttempt(attempt)
+
+	switch resp.StatusCode {
+	case http.StatusGone:
+		h.retireEndpoint(url)
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		h.retryAfter = getRetryAfter(resp.Header, time.Now())
+	default:
+		// successful manual resend brings the retired endpoint back
+		if h.resend && h.redis != nil && resp.StatusCode < http.StatusBadRequest {
+			if err := RestoreEndpoint(h.redis, h.order.GetProject().GetId(), url); err != nil {
+				h.HandleError(LoggerEndpointRedis, err, nil)
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) updateOrder(order *billingpb.Order) error {
+	// order of the test notification is the fixture which isn't stored
+	if h.test {
+		return nil
+	}
+
+	_, err := h.repository.UpdateOrder(context.TODO(), order)
+
+	if err != nil {
+		metrics.UpdateOrderErrorsTotal.Inc()
+	}
+
+	return err
 }
 
 func (h *Handler) SendToUserCentrifugo(order *billingpb.Order) error {
@@ -276,6 +423,10 @@ func (h *Handler) SendToUserCentrifugo(order *billingpb.Order) error {
 }
 
 func (h *Handler) sendToAdminCentrifugo(order *billingpb.Order, message string) error {
+	if h.test {
+		return nil
+	}
+
 	msg := map[string]interface{}{
 		centrifugoFieldCustomMessage: message,
 		centrifugoFieldOrderId:       order.GetUuid(),
@@ -302,34 +453,131 @@ func (h *Handler) HandleError(msg string, err error, t Table) {
 
 func (h *Handler) handleErrorWithRetry(msg string, err error, t Table) error {
 	h.HandleError(msg, err, t)
+	h.lastError = err
 	return h.retry()
 }
 
 func (h *Handler) retry() (err error) {
-	if h.RetryCount >= RetryMaxCount {
+	// manual resend returns result to the caller instead of scheduling retries
+	if h.resend {
+		return
+	}
+
+	protocol := h.order.GetProject().GetCallbackProtocol()
+
+	if h.endpointRetired {
+		zap.S().Infow(loggerRetiredNoRetries, "order_id", h.order.Id)
+		h.park()
+		return
+	}
+
+	delay, ttl, broker := h.getNextRetryBroker()
+
+	// delivery deferred by the open circuit breaker or the rate limits wasn't tried, so neither the retry count
+	// nor the retry age and the notification lifetime are spent while it waits
+	deferred := isDeliveryDeferred(h.lastError)
+
+	if deferred {
+		h.retryDeferred += int64(delay)
+	}
+
+	// the next try later than the notification lifetime would never be made
+	if h.isExpired(time.Now().Add(time.Duration(delay) * time.Second)) {
+		h.expire()
+		return
+	}
+
+	if !h.canRetry() {
+		metrics.RetriesExhaustedTotal.WithLabelValues(protocol).Inc()
 		zap.S().Infow(loggerNotificationRetryEnded, "order_id", h.order.Id)
 		if err := h.sendToAdminCentrifugo(h.order, loggerNotificationRetryEnded); err != nil {
 			h.HandleError(LoggerNotificationCentrifugo, err, nil)
 		}
+		h.park()
+		return
+	}
+
+	if broker == nil {
+		err = errors.New(errorRetryBrokerNotFound)
+		h.HandleError(loggerErrorNotificationRetryFailed, err, Table{"retry_count": h.RetryCount})
 		return
 	}
 
-	err = h.retBrok.Publish(h.dlv.RoutingKey, h.order, amqp.Table{"x-retry-count": h.RetryCount + 1})
+	firstAttemptAt := h.getFirstAttemptAt()
+	retryCount := h.RetryCount + 1
+
+	if deferred {
+		retryCount = h.RetryCount
+	}
+
+	headers := amqp.Table{
+		retryCountHeader:        retryCount,
+		retryFirstAttemptHeader: firstAttemptAt,
+		retryNotBeforeHeader:    time.Now().Unix() + int64(delay),
+		retryDeferredHeader:     h.retryDeferred,
+		retryHistoryHeader:      h.getRetryHistory(),
+	}
+
+	// the replayed notification keeps the lifetime counted from the replay
+	if replayedAt := getInt64Header(h.dlv, replayedAtHeader); replayedAt > 0 {
+		headers[replayedAtHeader] = replayedAt
+	}
+
+	err = broker.Publish(h.dlv.RoutingKey, h.order, headers)
 
 	if err != nil {
-		h.HandleError(loggerErrorNotificationRetryFailed, err, Table{"retry_count": h.RetryCount})
+		h.HandleError(loggerErrorNotificationRetryFailed, err, Table{"retry_count": h.RetryCount, "retry_delay": ttl})
+
+		// the deferral is counted again by the next publish
+		if deferred {
+			h.retryDeferred -= int64(delay)
+		}
+
 		time.Sleep(5 * time.Second)
 		return h.retry()
 	}
 
+	metrics.RetriesTotal.WithLabelValues(protocol).Inc()
 	h.retryProcess = true
+	h.result = metrics.ResultRetried
+
+	if deferred {
+		h.result = metrics.ResultDeferred
+	}
+
 	return
 }
 
+// GetResult returns outcome of the notification processing: the outcome recorded by the handler, otherwise
+// the delivery if the notifier succeeded
+func (h *Handler) GetResult(err error) string {
+	if h.result != "" {
+		return h.result
+	}
+
+	if err != nil {
+		return metrics.ResultFail
+	}
+
+	return metrics.ResultDelivered
+}
+
+// isDeliveryDeferred checks that the http request wasn't sent because of the circuit breaker or the rate limits
+func isDeliveryDeferred(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited)
+}
+
 func (h *Handler) getStat(key string) (*NotificationStat, error) {
 	result := &NotificationStat{
 		StatKey: key,
 	}
+
+	// test notification is sent regardless of the stat and never changes it
+	if h.test {
+		result.data = map[string]string{}
+		return result, nil
+	}
+
 	var err error
 	result.data, err = h.redis.HGetAll(key).Result()
 	if err != nil {
@@ -340,6 +588,10 @@ func (h *Handler) getStat(key string) (*NotificationStat, error) {
 }
 
 func (h *Handler) setStat(key string, field string, val bool) error {
+	if h.test {
+		return nil
+	}
+
 	err := h.redis.HSet(key, field, val).Err()
 	if err != nil {
 		h.HandleError("set notification stat failed", err, nil)
@@ -142,9 +142,11 @@ func (suite *HandlerTestSuite) SetupTest() {
 	suite.handler = NewHandler(
 		order,
 		bs,
+		RetryBrokers{RetryDlxTimeout: mock.NewBrokerMockOk()},
 		mock.NewBrokerMockOk(),
 		mock.NewBrokerMockOk(),
-		mock.NewBrokerMockOk(),
+		mock.NewParkingMockOk(),
+		suite.httpClient,
 		redisCl,
 		amqp.Delivery{Headers: amqp.Table{retryCountHeader: int32(1)}},
 		cfg,
@@ -157,7 +159,7 @@ func (suite *HandlerTestSuite) SetupTest() {
 	assert.Implements(suite.T(), (*billingpb.BillingService)(nil), suite.handler.repository)
 	assert.Implements(suite.T(), (*CentrifugoInterface)(nil), suite.handler.centrifugoPaymentForm)
 	assert.Implements(suite.T(), (*CentrifugoInterface)(nil), suite.handler.centrifugoDashboard)
-	assert.Implements(suite.T(), (*rabbitmq.BrokerInterface)(nil), suite.handler.retBrok)
+	assert.Implements(suite.T(), (*rabbitmq.BrokerInterface)(nil), suite.handler.retryBrokers[RetryDlxTimeout])
 	assert.Implements(suite.T(), (*rabbitmq.BrokerInterface)(nil), suite.handler.taxjarTransactionsBroker)
 	assert.Implements(suite.T(), (*rabbitmq.BrokerInterface)(nil), suite.handler.taxjarRefundsBroker)
 	assert.IsType(suite.T(), amqp.Delivery{}, suite.handler.dlv)
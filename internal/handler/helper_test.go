@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	billMocks "github.com/paysuper/paysuper-proto/go/billingpb/mocks"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/mock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	mock2 "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+)
+
+// newTestHandler returns the handler of the order completed by the payment system for the project notified
+// by the callback protocol. The handler uses redis of the test config and the billing service mock which
+// accepts order updates.
+func newTestHandler(s suite.TestingSuite, protocol string) *Handler {
+	cfg, err := config.NewConfig()
+	assert.NoError(s.T(), err)
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisHost,
+		Password: cfg.RedisPassword,
+	})
+
+	_, err = rdb.Ping().Result()
+	assert.NoError(s.T(), err)
+
+	bs := &billMocks.BillingService{}
+	bs.On("UpdateOrder", mock2.Anything, mock2.Anything, mock2.Anything).Return(&billingpb.EmptyResponse{}, nil)
+
+	h := &Handler{
+		order: &billingpb.Order{
+			Id:             "254e3736-000f-5000-8000-178d1d80bf70",
+			Uuid:           "254e3736-000f-5000-8000-178d1d80bf70",
+			Status:         recurringpb.OrderPublicStatusProcessed,
+			PrivateStatus:  recurringpb.OrderStatusPaymentSystemComplete,
+			ProjectOrderId: "254e3736-000f-5000-8000-178d1d80bf71",
+			CreatedAt:      ptypes.TimestampNow(),
+			UpdatedAt:      ptypes.TimestampNow(),
+			Project: &billingpb.ProjectOrder{
+				Id:                "254e3736-000f-5000-8000-178d1d80bf70",
+				SecretKey:         "Unit Test",
+				UrlProcessPayment: processUrl,
+				CallbackProtocol:  protocol,
+			},
+		},
+		repository:   bs,
+		redis:        rdb,
+		cfg:          cfg,
+		dlv:          amqp.Delivery{RoutingKey: "*"},
+		retryBrokers: RetryBrokers{RetryDlxTimeout: mock.NewBrokerMockOk()},
+		httpClient:   http.DefaultClient,
+	}
+
+	h.centrifugoDashboard = NewCentrifugo(cfg.CentrifugoDashboard, mock.NewCentrifugoTransportStatusOk())
+
+	return h
+}
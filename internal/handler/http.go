@@ -0,0 +1,363 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	errorHostNotResolved  = "destination host not resolved"
+	errorTooManyRedirects = "stopped after %d redirects"
+	errorUrlSchemeInvalid = "url scheme must be http or https"
+
+	centrifugoMsgNotificationUrlForbidden = "notification url resolves to forbidden address"
+
+	maxRedirects = 10
+
+	// Maximum size of the project response body read by the notifier, the rest of the body is ignored
+	responseBodyMaxLength = 1 << 20
+	// Maximum size of the request and the response body written to the log
+	logBodyMaxLength = 4096
+)
+
+var (
+	ErrAddressForbidden = errors.New("destination address is forbidden")
+
+	// Networks which are not allowed as webhook destination: loopback, link-local (including cloud metadata
+	// endpoints), private, carrier-grade NAT, multicast and reserved ranges
+	forbiddenNetworks = mustParseCIDRs(
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.0.2.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"64:ff9b::/96",
+		"2001::/32",
+		"2002::/16",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	)
+)
+
+// AddressGuard protects outbound requests against server-side request forgery.
+// Destination host is resolved on every dial and the connection is made only to the checked address.
+type AddressGuard struct {
+	allowedNetworks []*net.IPNet
+	allowedHosts    map[string]bool
+	dialer          *net.Dialer
+	resolver        *net.Resolver
+}
+
+type loggedTransport struct {
+	Transport http.RoundTripper
+}
+
+// NewAddressGuard creates guard with allowlist of host names, ip addresses and networks in CIDR notation
+// which are allowed as destination regardless of the forbidden ranges
+func NewAddressGuard(allowlist []string) (*AddressGuard, error) {
+	g := &AddressGuard{
+		allowedHosts: make(map[string]bool),
+		dialer:       &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		resolver:     net.DefaultResolver,
+	}
+
+	for _, v := range allowlist {
+		v = strings.TrimSpace(v)
+
+		if v == "" {
+			continue
+		}
+
+		if strings.Contains(v, "/") {
+			_, n, err := net.ParseCIDR(v)
+
+			if err != nil {
+				return nil, err
+			}
+
+			g.allowedNetworks = append(g.allowedNetworks, n)
+			continue
+		}
+
+		if ip := net.ParseIP(v); ip != nil {
+			g.allowedNetworks = append(g.allowedNetworks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		g.allowedHosts[strings.ToLower(v)] = true
+	}
+
+	return g, nil
+}
+
+// CheckIP returns ErrAddressForbidden if address is in forbidden range and not allowed explicitly
+func (g *AddressGuard) CheckIP(ip net.IP) error {
+	for _, n := range g.allowedNetworks {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+
+	for _, n := range forbiddenNetworks {
+		if n.Contains(ip) {
+			return ErrAddressForbidden
+		}
+	}
+
+	return nil
+}
+
+// CheckUrl checks url scheme and all addresses the url host resolves to
+func (g *AddressGuard) CheckUrl(ctx context.Context, u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New(errorUrlSchemeInvalid)
+	}
+
+	host := u.Hostname()
+
+	if g.allowedHosts[strings.ToLower(host)] {
+		return nil
+	}
+
+	ips, err := g.lookup(ctx, host)
+
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if err := g.CheckIP(ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DialContext resolves the host and connects to the first of its addresses that is not forbidden
+func (g *AddressGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if g.allowedHosts[strings.ToLower(host)] {
+		return g.dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := g.lookup(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+
+	for _, ip := range ips {
+		if err := g.CheckIP(ip); err != nil {
+			lastErr = err
+			continue
+		}
+
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+func (g *AddressGuard) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	addrs, err := g.resolver.LookupIPAddr(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New(errorHostNotResolved)
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+
+	return ips, nil
+}
+
+// WithDialer returns copy of the guard which connects with specified dialer
+func (g *AddressGuard) WithDialer(dialer *net.Dialer) *AddressGuard {
+	c := *g
+	c.dialer = dialer
+
+	return &c
+}
+
+// NewHttpClient creates http client for requests to projects protected by address guard.
+// Redirect destinations are checked by the guard too. Zero settings leave defaults of the http package.
+func NewHttpClient(guard *AddressGuard, settings *config.HttpClient) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(settings.ConnectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	guard = guard.WithDialer(dialer)
+
+	transport := &http.Transport{
+		DialContext:           guard.DialContext,
+		MaxIdleConns:          settings.MaxIdleConns,
+		MaxIdleConnsPerHost:   settings.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       settings.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(settings.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(settings.TlsHandshakeTimeout) * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	redirects := settings.MaxRedirects
+
+	if redirects == 0 {
+		redirects = maxRedirects
+	}
+
+	return &http.Client{
+		Transport: &loggedTransport{Transport: transport},
+		Timeout:   time.Duration(settings.Timeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if redirects < 0 {
+				return http.ErrUseLastResponse
+			}
+
+			if len(via) >= redirects {
+				return fmt.Errorf(errorTooManyRedirects, redirects)
+			}
+
+			return guard.CheckUrl(req.Context(), req.URL)
+		},
+	}
+}
+
+// HttpClients contains the shared http client for requests to projects and the clients
+// of projects with overridden http settings
+type HttpClients struct {
+	client   *http.Client
+	projects map[string]*http.Client
+}
+
+// NewHttpClients creates the shared http client and the clients of projects with overridden http settings
+func NewHttpClients(guard *AddressGuard, cfg *config.Config) *HttpClients {
+	clients := &HttpClients{
+		client:   NewHttpClient(guard, cfg.GetHttpClient("")),
+		projects: make(map[string]*http.Client),
+	}
+
+	for id, p := range cfg.Projects {
+		if p == nil || p.Http == nil {
+			continue
+		}
+
+		clients.projects[id] = NewHttpClient(guard, cfg.GetHttpClient(id))
+	}
+
+	return clients
+}
+
+// Get returns http client for requests to the project with specified identifier
+func (c *HttpClients) Get(projectId string) *http.Client {
+	if client, ok := c.projects[projectId]; ok {
+		return client
+	}
+
+	return c.client
+}
+
+func (t *loggedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+
+	if req.Body != nil {
+		reqBody, _ = ioutil.ReadAll(req.Body)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+	rsp, err := t.Transport.RoundTrip(req)
+
+	if err != nil {
+		zap.L().Error(
+			req.URL.String(),
+			zap.Error(err),
+			zap.Any("request_headers", req.Header),
+			zap.ByteString("request_body", truncateLogBody(reqBody)),
+		)
+		return rsp, err
+	}
+
+	var rspBody []byte
+
+	if rsp.Body != nil {
+		rspBody, err = ioutil.ReadAll(io.LimitReader(rsp.Body, logBodyMaxLength+1))
+
+		if err != nil {
+			return rsp, err
+		}
+
+		// the part of the body read for the log is returned to the reader, the rest is read by the caller
+		rsp.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(rspBody), rsp.Body), Closer: rsp.Body}
+	}
+
+	zap.L().Info(
+		req.URL.String(),
+		zap.Any("request_headers", req.Header),
+		zap.ByteString("request_body", truncateLogBody(reqBody)),
+		zap.Int("response_status", rsp.StatusCode),
+		zap.Any("response_headers", rsp.Header),
+		zap.ByteString("response_body", truncateLogBody(rspBody)),
+	)
+
+	return rsp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// truncateLogBody returns the body cut to the maximum length of the log field
+func truncateLogBody(b []byte) []byte {
+	if len(b) <= logBodyMaxLength {
+		return b
+	}
+
+	return append(b[:logBodyMaxLength:logBodyMaxLength], attemptBodyTruncatedSuffix...)
+}
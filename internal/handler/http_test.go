@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type HttpTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func Test_Http(t *testing.T) {
+	suite.Run(t, new(HttpTestSuite))
+}
+
+func (suite *HttpTestSuite) SetupTest() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/redirect/ok", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+
+	suite.server = httptest.NewServer(mux)
+}
+
+func (suite *HttpTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *HttpTestSuite) TestHttp_CheckIP() {
+	guard, err := NewAddressGuard(nil)
+	assert.NoError(suite.T(), err)
+
+	forbidden := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00:ec2::254", "::ffff:127.0.0.1", "2002:7f00:1::1",
+		"2001:0:4136:e378:8000:63bf:80ff:fffe",
+	}
+
+	for _, v := range forbidden {
+		assert.True(suite.T(), errors.Is(guard.CheckIP(net.ParseIP(v)), ErrAddressForbidden), v)
+	}
+
+	for _, v := range []string{"8.8.8.8", "93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.NoError(suite.T(), guard.CheckIP(net.ParseIP(v)), v)
+	}
+}
+
+func (suite *HttpTestSuite) TestHttp_CheckIP_Allowlist() {
+	guard, err := NewAddressGuard([]string{"10.0.0.0/24", "127.0.0.1", "notifier.local"})
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), guard.CheckIP(net.ParseIP("10.0.0.15")))
+	assert.NoError(suite.T(), guard.CheckIP(net.ParseIP("127.0.0.1")))
+	assert.Error(suite.T(), guard.CheckIP(net.ParseIP("10.0.1.15")))
+	assert.Error(suite.T(), guard.CheckIP(net.ParseIP("127.0.0.2")))
+
+	u, _ := url.Parse("http://notifier.local/webhook")
+	assert.NoError(suite.T(), guard.CheckUrl(context.Background(), u))
+}
+
+func (suite *HttpTestSuite) TestHttp_NewAddressGuard_InvalidNetwork() {
+	_, err := NewAddressGuard([]string{"10.0.0.0/99"})
+	assert.Error(suite.T(), err)
+}
+
+func (suite *HttpTestSuite) TestHttp_CheckUrl_Forbidden() {
+	guard, err := NewAddressGuard(nil)
+	assert.NoError(suite.T(), err)
+
+	u, _ := url.Parse("http://169.254.169.254/latest/meta-data/")
+	assert.True(suite.T(), errors.Is(guard.CheckUrl(context.Background(), u), ErrAddressForbidden))
+
+	u, _ = url.Parse("http://localhost:8080/")
+	assert.True(suite.T(), errors.Is(guard.CheckUrl(context.Background(), u), ErrAddressForbidden))
+
+	u, _ = url.Parse("file:///etc/passwd")
+	assert.EqualError(suite.T(), guard.CheckUrl(context.Background(), u), errorUrlSchemeInvalid)
+}
+
+func (suite *HttpTestSuite) TestHttp_Client_LoopbackForbidden() {
+	guard, err := NewAddressGuard(nil)
+	assert.NoError(suite.T(), err)
+
+	_, err = NewHttpClient(guard, &config.HttpClient{}).Get(suite.server.URL + "/ok")
+	assert.True(suite.T(), errors.Is(err, ErrAddressForbidden))
+}
+
+func (suite *HttpTestSuite) TestHttp_Client_Allowlisted() {
+	guard, err := NewAddressGuard([]string{"127.0.0.1"})
+	assert.NoError(suite.T(), err)
+
+	rsp, err := NewHttpClient(guard, &config.HttpClient{}).Get(suite.server.URL + "/ok")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, rsp.StatusCode)
+}
+
+func (suite *HttpTestSuite) TestHttp_Client_RedirectForbidden() {
+	guard, err := NewAddressGuard([]string{"127.0.0.1"})
+	assert.NoError(suite.T(), err)
+
+	_, err = NewHttpClient(guard, &config.HttpClient{}).Get(suite.server.URL + "/redirect")
+	assert.True(suite.T(), errors.Is(err, ErrAddressForbidden))
+}
+
+func (suite *HttpTestSuite) TestHttp_Client_Timeout() {
+	guard, err := NewAddressGuard([]string{"127.0.0.1"})
+	assert.NoError(suite.T(), err)
+
+	_, err = NewHttpClient(guard, &config.HttpClient{Timeout: 1}).Get(suite.server.URL + "/slow")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *HttpTestSuite) TestHttp_Client_RedirectsDisabled() {
+	guard, err := NewAddressGuard([]string{"127.0.0.1"})
+	assert.NoError(suite.T(), err)
+
+	rsp, err := NewHttpClient(guard, &config.HttpClient{}).Get(suite.server.URL + "/redirect/ok")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusOK, rsp.StatusCode)
+
+	rsp, err = NewHttpClient(guard, &config.HttpClient{MaxRedirects: -1}).Get(suite.server.URL + "/redirect/ok")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), http.StatusFound, rsp.StatusCode)
+}
+
+func (suite *HttpTestSuite) TestHttp_HttpClients_ProjectOverride() {
+	guard, err := NewAddressGuard(nil)
+	assert.NoError(suite.T(), err)
+
+	cfg := &config.Config{
+		HttpTimeout: 30,
+		Projects: config.Projects{
+			"project1": {Http: &config.HttpClient{Timeout: 5, MaxRedirects: -1}},
+			"project2": {SignatureScheme: SignatureSchemeLegacy},
+		},
+	}
+
+	clients := NewHttpClients(guard, cfg)
+	assert.Equal(suite.T(), 30*time.Second, clients.Get("project2").Timeout)
+	assert.Equal(suite.T(), 5*time.Second, clients.Get("project1").Timeout)
+	assert.Equal(suite.T(), clients.Get(""), clients.Get("project2"))
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+	rabbitmq "gopkg.in/ProtocolONE/rabbitmq.v1/pkg"
+	"sync"
+	"time"
+)
+
+const (
+	ParkingExchangeName = "notify-payment-parking"
+	ParkingQueueName    = "notify-payment-parking"
+	// Routing key of the parked notifications, the parking queue is bound to the exchange with it
+	ParkingRoutingKey = "parked"
+
+	parkedAtHeader       = "x-parked-at"
+	parkedErrorHeader    = "x-parked-error"
+	replayedAtHeader     = "x-replayed-at"
+	retryHistoryHeader   = "x-retry-history"
+	retryHistoryMaxCount = 50
+
+	errorParkingMessageMalformed = "parked message can't be decoded to order"
+
+	loggerErrorNotificationParkingFailed = "Publish exhausted notification to parking queue failed"
+	loggerNotificationParked             = "Exhausted notification published to parking queue"
+)
+
+type ParkingInterface interface {
+	// Publish puts exhausted notification of the order to the parking queue
+	Publish(*billingpb.Order, amqp.Table) error
+	// Replay passes parked orders with specified identifiers (all orders if identifiers are empty) to callback
+	// and removes them from the parking queue, at most limit orders processed if limit is positive
+	Replay(ids []string, limit int, fn func(*billingpb.Order) error) (int, error)
+}
+
+// parkingChannel is the part of the amqp channel used to read the parking queue on replay
+type parkingChannel interface {
+	QueueInspect(name string) (amqp.Queue, error)
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Close() error
+}
+
+// Parking publishes exhausted notifications by the broker like the other queues of the service, so the connection
+// is restored by the broker. The parking queue is read on replay only by the channel opened for the replay.
+type Parking struct {
+	broker  rabbitmq.BrokerInterface
+	channel func() (parkingChannel, error)
+	mx      sync.Mutex
+}
+
+// parkingConnection closes the connection opened for the replay together with its channel
+type parkingConnection struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func NewParking(address string) (ParkingInterface, error) {
+	broker, err := rabbitmq.NewBroker(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	broker.(*rabbitmq.Broker).Opts.QueueOpts.Name = ParkingQueueName
+	broker.SetExchangeName(ParkingExchangeName)
+
+	channel := func() (parkingChannel, error) {
+		conn, err := amqp.Dial(address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ch, err := conn.Channel()
+
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return &parkingConnection{Channel: ch, conn: conn}, nil
+	}
+
+	return newParking(broker, channel), nil
+}
+
+func newParking(broker rabbitmq.BrokerInterface, channel func() (parkingChannel, error)) *Parking {
+	return &Parking{broker: broker, channel: channel}
+}
+
+func (c *parkingConnection) Close() error {
+	_ = c.Channel.Close()
+	return c.conn.Close()
+}
+
+func (p *Parking) Publish(order *billingpb.Order, headers amqp.Table) error {
+	return p.broker.Publish(ParkingRoutingKey, order, headers)
+}
+
+func (p *Parking) Replay(ids []string, limit int, fn func(*billingpb.Order) error) (int, error) {
+	// only one replay at time to avoid passing same message twice
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	ch, err := p.channel()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// messages that not acknowledged are returned to the queue on channel close
+	defer ch.Close()
+
+	q, err := ch.QueueInspect(ParkingQueueName)
+
+	if err != nil {
+		// the queue is declared by the first parked notification
+		if e, ok := err.(*amqp.Error); ok && e.Code == amqp.NotFound {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	selected := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	replayed := 0
+
+	for i := 0; i < q.Messages; i++ {
+		if limit > 0 && replayed >= limit {
+			break
+		}
+
+		msg, ok, err := ch.Get(ParkingQueueName, false)
+
+		if err != nil {
+			return replayed, err
+		}
+
+		if !ok {
+			break
+		}
+
+		order := &billingpb.Order{}
+
+		if err := proto.Unmarshal(msg.Body, order); err != nil {
+			zap.S().Errorw(errorParkingMessageMalformed, "error", err, "message_id", msg.MessageId)
+			continue
+		}
+
+		if len(selected) > 0 && !selected[order.GetId()] {
+			continue
+		}
+
+		if err := fn(order); err != nil {
+			return replayed, err
+		}
+
+		if err := msg.Ack(false); err != nil {
+			return replayed, err
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// GetReplayHeaders returns headers of the replayed parked notification: the retry count is reset
+// and the lifetime of the notification is counted from the replay
+func GetReplayHeaders() amqp.Table {
+	return amqp.Table{
+		retryCountHeader: int32(0),
+		replayedAtHeader: time.Now().Unix(),
+	}
+}
+
+// park publishes the order with the last error and the attempts history to the parking queue
+func (h *Handler) park() {
+	if h.parking == nil {
+		return
+	}
+
+	lastError := ""
+
+	if h.lastError != nil {
+		lastError = h.lastError.Error()
+	}
+
+	headers := amqp.Table{
+		retryCountHeader:        h.RetryCount,
+		retryFirstAttemptHeader: h.retryFirstAttemptAt,
+		retryHistoryHeader:      h.getRetryHistory(),
+		parkedAtHeader:          time.Now().Unix(),
+		parkedErrorHeader:       lastError,
+	}
+
+	if err := h.parking.Publish(h.order, headers); err != nil {
+		h.HandleError(loggerErrorNotificationParkingFailed, err, nil)
+		return
+	}
+
+	h.result = metrics.ResultParked
+	zap.S().Infow(loggerNotificationParked, "order_id", h.order.Id, "retry_count", h.RetryCount)
+}
+
+// getRetryHistory returns the attempts history with the current attempt appended
+func (h *Handler) getRetryHistory() []interface{} {
+	lastError := ""
+
+	if h.lastError != nil {
+		lastError = h.lastError.Error()
+	}
+
+	history := append(h.retryHistory, amqp.Table{
+		"retry_count": h.RetryCount,
+		"at":          time.Now().Unix(),
+		"error":       lastError,
+	})
+
+	if len(history) > retryHistoryMaxCount {
+		history = history[len(history)-retryHistoryMaxCount:]
+	}
+
+	return history
+}
+
+func getRetryHistory(dlv amqp.Delivery) []interface{} {
+	v, ok := dlv.Headers[retryHistoryHeader]
+
+	if !ok {
+		return nil
+	}
+
+	history, ok := v.([]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	return history
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gogo/protobuf/proto"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/mock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type parkingChannelMock struct {
+	messages []amqp.Delivery
+	acked    []uint64
+	closed   bool
+}
+
+func (c *parkingChannelMock) QueueInspect(name string) (amqp.Queue, error) {
+	return amqp.Queue{Name: name, Messages: len(c.messages)}, nil
+}
+
+func (c *parkingChannelMock) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	if len(c.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	msg := c.messages[0]
+	c.messages = c.messages[1:]
+	msg.Acknowledger = c
+
+	return msg, true, nil
+}
+
+func (c *parkingChannelMock) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *parkingChannelMock) Ack(tag uint64, multiple bool) error {
+	c.acked = append(c.acked, tag)
+	return nil
+}
+
+func (c *parkingChannelMock) Nack(tag uint64, multiple bool, requeue bool) error {
+	return nil
+}
+
+func (c *parkingChannelMock) Reject(tag uint64, requeue bool) error {
+	return nil
+}
+
+type ParkingTestSuite struct {
+	suite.Suite
+	handler *Handler
+	parking *mock.ParkingMockOk
+}
+
+func Test_Parking(t *testing.T) {
+	suite.Run(t, new(ParkingTestSuite))
+}
+
+func (suite *ParkingTestSuite) SetupTest() {
+	suite.parking = mock.NewParkingMockOk()
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id:      "254e3736-000f-5000-8000-178d1d80bf70",
+			Uuid:    "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{Id: "254e3736-000f-5000-8000-178d1d80bf70"},
+		},
+		cfg:                 &config.Config{},
+		retryPolicy:         &config.RetryPolicy{MaxCount: 3},
+		retryBrokers:        RetryBrokers{RetryDlxTimeout: mock.NewBrokerMockOk()},
+		retryFirstAttemptAt: 1577836800,
+		parking:             suite.parking,
+		centrifugoDashboard: NewCentrifugo(&config.Centrifugo{}, mock.NewCentrifugoTransportStatusOk()),
+	}
+}
+
+func (suite *ParkingTestSuite) TearDownTest() {}
+
+func (suite *ParkingTestSuite) TestParking_retry_NotExhausted() {
+	suite.handler.RetryCount = 1
+
+	err := suite.handler.handleErrorWithRetry(loggerErrorNotificationRetry, errors.New("some error"), nil)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+	assert.Empty(suite.T(), suite.parking.Parked)
+}
+
+func (suite *ParkingTestSuite) TestParking_retry_Exhausted() {
+	suite.handler.RetryCount = 3
+	suite.handler.retryHistory = []interface{}{
+		amqp.Table{"retry_count": int32(1), "at": int64(1577836800), "error": "first error"},
+	}
+
+	err := suite.handler.handleErrorWithRetry(loggerErrorNotificationRetry, errors.New("some error"), nil)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+
+	assert.Len(suite.T(), suite.parking.Parked, 1)
+	assert.Equal(suite.T(), suite.handler.order.Id, suite.parking.Parked[0].Id)
+
+	headers := suite.parking.Headers[0]
+	assert.Equal(suite.T(), "some error", headers[parkedErrorHeader])
+	assert.Equal(suite.T(), int32(3), headers[retryCountHeader])
+	assert.Equal(suite.T(), int64(1577836800), headers[retryFirstAttemptHeader])
+	assert.Contains(suite.T(), headers, parkedAtHeader)
+
+	history, ok := headers[retryHistoryHeader].([]interface{})
+	assert.True(suite.T(), ok)
+	assert.Len(suite.T(), history, 2)
+	assert.Equal(suite.T(), "some error", history[1].(amqp.Table)["error"])
+}
+
+func (suite *ParkingTestSuite) TestParking_getRetryHistory_Bounded() {
+	for i := 0; i < retryHistoryMaxCount; i++ {
+		suite.handler.retryHistory = append(suite.handler.retryHistory, amqp.Table{"retry_count": int32(i)})
+	}
+
+	history := suite.handler.getRetryHistory()
+	assert.Len(suite.T(), history, retryHistoryMaxCount)
+	assert.Equal(suite.T(), int32(1), history[0].(amqp.Table)["retry_count"])
+}
+
+func (suite *ParkingTestSuite) TestParking_getRetryHistory_FromDelivery() {
+	assert.Nil(suite.T(), getRetryHistory(amqp.Delivery{}))
+
+	dlv := amqp.Delivery{Headers: amqp.Table{retryHistoryHeader: []interface{}{amqp.Table{"error": "some error"}}}}
+	assert.Len(suite.T(), getRetryHistory(dlv), 1)
+}
+
+func (suite *ParkingTestSuite) TestParking_Replay() {
+	ch := &parkingChannelMock{}
+
+	for i, id := range []string{"order-1", "order-2", "order-3"} {
+		b, err := proto.Marshal(&billingpb.Order{Id: id})
+		assert.NoError(suite.T(), err)
+		ch.messages = append(ch.messages, amqp.Delivery{DeliveryTag: uint64(i + 1), Body: b})
+	}
+
+	ch.messages = append(ch.messages, amqp.Delivery{DeliveryTag: 4, Body: []byte("malformed")})
+
+	parking := newParking(mock.NewBrokerMockOk(), func() (parkingChannel, error) {
+		return ch, nil
+	})
+
+	var orders []string
+	replayed, err := parking.Replay([]string{"order-1", "order-3"}, 0, func(order *billingpb.Order) error {
+		orders = append(orders, order.Id)
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 2, replayed)
+	assert.Equal(suite.T(), []string{"order-1", "order-3"}, orders)
+	assert.Equal(suite.T(), []uint64{1, 3}, ch.acked)
+	assert.True(suite.T(), ch.closed)
+}
+
+func (suite *ParkingTestSuite) TestParking_Replay_LimitAndError() {
+	ch := &parkingChannelMock{}
+
+	for i, id := range []string{"order-1", "order-2", "order-3"} {
+		b, err := proto.Marshal(&billingpb.Order{Id: id})
+		assert.NoError(suite.T(), err)
+		ch.messages = append(ch.messages, amqp.Delivery{DeliveryTag: uint64(i + 1), Body: b})
+	}
+
+	parking := newParking(mock.NewBrokerMockOk(), func() (parkingChannel, error) {
+		return ch, nil
+	})
+
+	replayed, err := parking.Replay(nil, 1, func(order *billingpb.Order) error {
+		return nil
+	})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, replayed)
+	assert.Equal(suite.T(), []uint64{1}, ch.acked)
+
+	replayed, err = parking.Replay(nil, 0, func(order *billingpb.Order) error {
+		return errors.New("some error")
+	})
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), 0, replayed)
+	assert.Equal(suite.T(), []uint64{1}, ch.acked)
+}
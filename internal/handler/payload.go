@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	payloadFieldSeparator = "."
+
+	errorPayloadOrderEmpty = "order is empty"
+)
+
+var (
+	// Order fields sent to projects by the v1 payload of the default protocol, the list must not be changed
+	payloadFieldsV1 = []string{
+		"id",
+		"uuid",
+		"transaction",
+		"object",
+		"status",
+		"description",
+		"type",
+		"project_order_id",
+		"project_account",
+		"project.id",
+		"project.merchant_id",
+		"project.name",
+		"payment_method.id",
+		"payment_method.name",
+		"payment_method.external_id",
+		"payment_method.group",
+		"total_payment_amount",
+		"order_amount",
+		"currency",
+		"charge_amount",
+		"charge_currency",
+		"tax",
+		"items",
+		"metadata",
+		"project_params",
+		"country",
+		"product_type",
+		"platform_id",
+		"is_production",
+		"canceled",
+		"refunded",
+		"refund",
+		"created_at",
+		"updated_at",
+		"payment_method_order_closed_at",
+		"user.id",
+		"user.object",
+		"user.external_id",
+		"user.locale",
+		"user.address.country",
+	}
+
+	// Order fields added by the v2 payload of the default protocol, the list must not be changed
+	payloadFieldsV2 = []string{
+		"canceled_at",
+		"cancellation_reason",
+		"refunded_at",
+		"receipt_number",
+		"receipt_url",
+	}
+
+	// Order fields sent to projects by the default protocol keyed by api version. New versions only add fields,
+	// so the payload of the pinned version stays the same. The v3 payload adds no order fields: it opts into
+	// the expanded event catalogue and the decline details which are outside of the order object.
+	payloadVersionFields = map[string][][]string{
+		config.PayloadVersionV1: {payloadFieldsV1},
+		config.PayloadVersionV2: {payloadFieldsV1, payloadFieldsV2},
+		config.PayloadVersionV3: {payloadFieldsV1, payloadFieldsV2},
+	}
+
+	// Order fields which are never sent to projects regardless of the settings: secrets and raw card data
+	forbiddenPayloadFields = []string{
+		"project.secret_key",
+		"payment_method.params",
+		"payment_method.card",
+		"payment_method_txn_params",
+		"payment_method_payer_account",
+		"payment_requisites",
+		"private_metadata",
+		"user.tech_email",
+	}
+)
+
+// payloadFields is the tree of projected fields, nil subtree means the whole field value is projected
+type payloadFields map[string]payloadFields
+
+func newPayloadFields(paths ...[]string) payloadFields {
+	tree := payloadFields{}
+
+	for _, list := range paths {
+		for _, path := range list {
+			path = strings.TrimSpace(path)
+
+			if path == "" {
+				continue
+			}
+
+			node := tree
+			parts := strings.Split(path, payloadFieldSeparator)
+
+			for i, part := range parts {
+				sub, ok := node[part]
+
+				if ok && sub == nil {
+					// the whole value is already projected
+					break
+				}
+
+				if i == len(parts)-1 {
+					node[part] = nil
+					break
+				}
+
+				if !ok {
+					sub = payloadFields{}
+					node[part] = sub
+				}
+
+				node = sub
+			}
+		}
+	}
+
+	return tree
+}
+
+// project returns copy of the value which contains only fields of the tree.
+// Fields of objects in arrays are projected by the same tree.
+func (f payloadFields) project(v interface{}) interface{} {
+	if f == nil {
+		return v
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{})
+
+		for k, sub := range f {
+			if fv, ok := t[k]; ok {
+				res[k] = sub.project(fv)
+			}
+		}
+
+		return res
+	case []interface{}:
+		res := make([]interface{}, 0, len(t))
+
+		for _, item := range t {
+			res = append(res, f.project(item))
+		}
+
+		return res
+	}
+
+	return v
+}
+
+// removePayloadField removes field with specified path from the value
+func removePayloadField(v interface{}, path []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(t, path[0])
+			return
+		}
+
+		if fv, ok := t[path[0]]; ok {
+			removePayloadField(fv, path[1:])
+		}
+	case []interface{}:
+		for _, item := range t {
+			removePayloadField(item, path)
+		}
+	}
+}
+
+// getPayloadVersion returns api version of the default protocol payload the project is pinned to
+func (h *Handler) getPayloadVersion() string {
+	v := h.cfg.GetPayloadVersion(h.order.GetProject().GetId())
+
+	if _, ok := payloadVersionFields[v]; !ok {
+		return config.PayloadVersionV1
+	}
+
+	return v
+}
+
+// getPayloadFields returns fields of the order sent to the project: the fields of the api version, and the project
+// opt-ins. PAYLOAD_FIELDS replaces the fields of the version only for projects which aren't pinned to a version,
+// so the payload of the pinned projects never changes.
+func (h *Handler) getPayloadFields() payloadFields {
+	fields := payloadVersionFields[h.getPayloadVersion()]
+
+	if h.cfg != nil {
+		project := h.cfg.GetProject(h.order.GetProject().GetId())
+
+		if len(h.cfg.PayloadFields) > 0 && project.PayloadVersion == "" {
+			fields = [][]string{h.cfg.PayloadFields}
+		}
+
+		fields = append(fields[:len(fields):len(fields)], project.PayloadFields)
+	}
+
+	return newPayloadFields(fields...)
+}
+
+// isCanonicalPayload checks that the payload of the project is serialized canonically. The v1 payload keeps
+// the protobuf json encoding of the order and the standard encoding of the message, so it never changes.
+func (h *Handler) isCanonicalPayload() bool {
+	return h.getPayloadVersion() != config.PayloadVersionV1
+}
+
+// marshalPayload returns json of the webhook payload in the encoding of the project payload version
+func (h *Handler) marshalPayload(v interface{}) ([]byte, error) {
+	if !h.isCanonicalPayload() {
+		return json.Marshal(v)
+	}
+
+	return MarshalCanonical(v)
+}
+
+// formatPayloadTime returns time of the webhook payload in the format of the project payload version
+func (h *Handler) formatPayloadTime(t time.Time) string {
+	if !h.isCanonicalPayload() {
+		return t.Format(time.RFC3339)
+	}
+
+	return FormatTimestamp(t)
+}
+
+// getOrderValue returns the order as the generic json value in the encoding of the project payload version
+func (h *Handler) getOrderValue() (interface{}, error) {
+	if h.isCanonicalPayload() {
+		return canonicalValue(reflect.ValueOf(h.order)), nil
+	}
+
+	b, err := json.Marshal(h.order)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var order map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if err := dec.Decode(&order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// GetOrderPayload returns the order projected to the fields allowed for the project.
+// Forbidden fields are removed even if they are allowed by the settings.
+func (h *Handler) GetOrderPayload() (map[string]interface{}, error) {
+	if h.order == nil {
+		return nil, errors.New(errorPayloadOrderEmpty)
+	}
+
+	order, err := h.getOrderValue()
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _ := h.getPayloadFields().project(order).(map[string]interface{})
+
+	for _, path := range forbiddenPayloadFields {
+		removePayloadField(payload, strings.Split(path, payloadFieldSeparator))
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type PayloadTestSuite struct {
+	suite.Suite
+	handler *Handler
+}
+
+func Test_Payload(t *testing.T) {
+	suite.Run(t, new(PayloadTestSuite))
+}
+
+func (suite *PayloadTestSuite) SetupTest() {
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id:             "254e3736-000f-5000-8000-178d1d80bf70",
+			Uuid:           "254e3736-000f-5000-8000-178d1d80bf70",
+			Status:         "processed",
+			Currency:       "RUB",
+			ProjectOrderId: "254e3736-000f-5000-8000-178d1d80bf71",
+			User: &billingpb.OrderUser{
+				Id:    "254e3736-000f-5000-8000-178d1d80bf72",
+				Email: "test@unit.test",
+				Ip:    "127.0.0.1",
+				Address: &billingpb.OrderBillingAddress{
+					Country: "RU",
+					City:    "St Petersburg",
+				},
+			},
+			Project: &billingpb.ProjectOrder{
+				Id:               "254e3736-000f-5000-8000-178d1d80bf73",
+				MerchantId:       "254e3736-000f-5000-8000-178d1d80bf74",
+				SecretKey:        "Unit Test",
+				CallbackProtocol: notifierHandlerDefault,
+			},
+			PaymentMethodPayerAccount: "400000...0002",
+			PaymentMethodTxnParams:    map[string]string{"pan": "400000...0002", "card_holder": "UNIT TEST"},
+			PaymentRequisites:         map[string]string{"pan": "400000******0002", "month": "12", "year": "2019"},
+			ReceiptUrl:                "https://unit.test/receipt",
+		},
+		cfg: &config.Config{},
+	}
+}
+
+func (suite *PayloadTestSuite) TearDownTest() {}
+
+func (suite *PayloadTestSuite) TestPayload_GetOrderPayload_Defaults() {
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), suite.handler.order.Id, payload["id"])
+	assert.Equal(suite.T(), suite.handler.order.ProjectOrderId, payload["project_order_id"])
+	assert.NotContains(suite.T(), payload, "payment_method_txn_params")
+	assert.NotContains(suite.T(), payload, "payment_requisites")
+	assert.NotContains(suite.T(), payload, "payment_method_payer_account")
+
+	project := payload["project"].(map[string]interface{})
+	assert.Equal(suite.T(), suite.handler.order.Project.Id, project["id"])
+	assert.NotContains(suite.T(), project, "secret_key")
+	assert.NotContains(suite.T(), project, "url_process_payment")
+
+	user := payload["user"].(map[string]interface{})
+	assert.Equal(suite.T(), suite.handler.order.User.Id, user["id"])
+	assert.NotContains(suite.T(), user, "email")
+	assert.NotContains(suite.T(), user, "ip")
+	assert.Equal(suite.T(), map[string]interface{}{"country": "RU"}, user["address"])
+
+	b, err := json.Marshal(payload)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), strings.Contains(string(b), "Unit Test"))
+	assert.False(suite.T(), strings.Contains(string(b), "400000"))
+}
+
+func (suite *PayloadTestSuite) TestPayload_GetOrderPayload_ProjectOptIns() {
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			PayloadFields: []string{"user.email", "user.address", "project", "payment_requisites.month"},
+		},
+	}
+
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+
+	user := payload["user"].(map[string]interface{})
+	assert.Equal(suite.T(), suite.handler.order.User.Email, user["email"])
+	assert.NotContains(suite.T(), user, "ip")
+	assert.Equal(suite.T(), "St Petersburg", user["address"].(map[string]interface{})["city"])
+
+	project := payload["project"].(map[string]interface{})
+	assert.Equal(suite.T(), suite.handler.order.Project.MerchantId, project["merchant_id"])
+	assert.NotContains(suite.T(), project, "secret_key")
+
+	assert.NotContains(suite.T(), payload, "payment_requisites")
+}
+
+func (suite *PayloadTestSuite) TestPayload_GetOrderPayload_ServiceFields() {
+	suite.handler.cfg.PayloadFields = []string{"id", "currency"}
+
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), map[string]interface{}{"id": suite.handler.order.Id, "currency": "RUB"}, payload)
+
+	// payload of the pinned project isn't changed by the service fields
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {PayloadVersion: config.PayloadVersionV1},
+	}
+
+	payload, err = suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), payload, "project_order_id")
+	assert.Contains(suite.T(), payload, "project")
+}
+
+func (suite *PayloadTestSuite) TestPayload_GetOrderPayload_Versions() {
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), payload, "receipt_url")
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {PayloadVersion: config.PayloadVersionV2},
+	}
+
+	payload, err = suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), suite.handler.order.ReceiptUrl, payload["receipt_url"])
+	assert.Equal(suite.T(), suite.handler.order.Id, payload["id"])
+}
+
+func (suite *PayloadTestSuite) TestPayload_getPayloadVersion() {
+	assert.Equal(suite.T(), config.PayloadVersionV1, suite.handler.getPayloadVersion())
+
+	suite.handler.cfg.PayloadVersion = config.PayloadVersionV2
+	assert.Equal(suite.T(), config.PayloadVersionV2, suite.handler.getPayloadVersion())
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {PayloadVersion: config.PayloadVersionV1},
+	}
+	assert.Equal(suite.T(), config.PayloadVersionV1, suite.handler.getPayloadVersion())
+
+	suite.handler.cfg.Projects[suite.handler.order.Project.Id].PayloadVersion = "v100"
+	assert.Equal(suite.T(), config.PayloadVersionV1, suite.handler.getPayloadVersion())
+}
+
+func (suite *PayloadTestSuite) TestPayload_newPayloadFields() {
+	fields := newPayloadFields([]string{"user.email", "user", "project.id", " "}, []string{"project.name"})
+	assert.Equal(suite.T(), payloadFields{"user": nil, "project": payloadFields{"id": nil, "name": nil}}, fields)
+}
+
+func (suite *PayloadTestSuite) TestPayload_getPaymentNotification_Thin() {
+	suite.handler.cfg.PayloadFetchUrl = "https://notifier.unit.test/"
+	suite.handler.cfg.PayloadFetchTtl = 60
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {PayloadMode: config.PayloadModeThin},
+	}
+	msg, err := (&Default{Handler: suite.handler}).getPaymentNotification(eventNameSuccess)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), msg.Object)
+	assert.Equal(suite.T(), suite.handler.order.Id, msg.OrderId)
+	assert.Equal(suite.T(), suite.handler.order.ProjectOrderId, msg.ProjectOrderId)
+	assert.NotEmpty(suite.T(), msg.FetchExpiresAt)
+
+	u, err := url.Parse(msg.FetchUrl)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "notifier.unit.test", u.Host)
+	assert.Equal(suite.T(), "/orders/"+suite.handler.order.Id+"/payload", u.Path)
+
+	expires, err := strconv.ParseInt(u.Query().Get(PayloadFetchQueryExpires), 10, 64)
+	assert.NoError(suite.T(), err)
+
+	token := u.Query().Get(PayloadFetchQueryToken)
+	secret := suite.handler.order.Project.SecretKey
+	now := time.Now()
+
+	assert.True(suite.T(), CheckPayloadFetchToken(secret, suite.handler.order.Id, expires, token, now))
+	assert.False(suite.T(), CheckPayloadFetchToken(secret, suite.handler.order.Id, expires+1, token, now))
+	assert.False(suite.T(), CheckPayloadFetchToken(secret, suite.handler.order.ProjectOrderId, expires, token, now))
+	assert.False(suite.T(), CheckPayloadFetchToken(secret, suite.handler.order.Id, expires, token, now.Add(2*time.Minute)))
+
+	b, err := json.Marshal(msg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(b), "\"object\"")
+}
+
+func (suite *PayloadTestSuite) TestPayload_getPaymentNotification_Full() {
+	msg, err := (&Default{Handler: suite.handler}).getPaymentNotification(eventNameSuccess)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), config.PayloadVersionV1, msg.ApiVersion)
+	assert.Equal(suite.T(), suite.handler.order.Id, msg.Object["id"])
+	assert.Empty(suite.T(), msg.FetchUrl)
+	assert.Empty(suite.T(), msg.OrderId)
+}
+
+func (suite *PayloadTestSuite) TestPayload_encodeMessage_V1Golden() {
+	suite.handler.cfg.PayloadFields = []string{"id", "created_at", "metadata"}
+	suite.handler.order.CreatedAt = &timestamp.Timestamp{Seconds: 1580000000, Nanos: 500}
+	suite.handler.order.Metadata = map[string]string{"a": "<b>"}
+
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+
+	msg := &OrderNotificationMessage{
+		Id:         "1",
+		ApiVersion: config.PayloadVersionV1,
+		Type:       "notification",
+		Event:      eventNameSuccess,
+		CreatedAt:  "2020-01-26T03:53:20+03:00",
+		Object:     payload,
+	}
+	endpoint := &notificationEndpoint{secretKey: suite.handler.order.Project.SecretKey}
+	b, _, err := (&Default{Handler: suite.handler}).encodeMessage(endpoint, msg, time.Now())
+	assert.NoError(suite.T(), err)
+
+	// the v1 payload must never change: protobuf timestamps, html escaping and the struct field order
+	expected := `{"id":"1","api_version":"v1","type":"notification","event":"` + eventNameSuccess + `","live":false,` +
+		`"created_at":"2020-01-26T03:53:20+03:00","expires_at":"","delivery_try":0,"object":{"created_at":` +
+		`{"nanos":500,"seconds":1580000000},"id":"254e3736-000f-5000-8000-178d1d80bf70","metadata":{"a":"\u003cb\u003e"}}}`
+	assert.Equal(suite.T(), expected, string(b))
+}
+
+func (suite *PayloadTestSuite) TestPayload_GetOrderPayload_Canonical() {
+	suite.handler.order.CreatedAt = &timestamp.Timestamp{Seconds: 1580000000, Nanos: 500}
+
+	payload, err := suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.IsType(suite.T(), map[string]interface{}{}, payload["created_at"])
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {PayloadVersion: config.PayloadVersionV2},
+	}
+
+	payload, err = suite.handler.GetOrderPayload()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "2020-01-26T00:53:20Z", payload["created_at"])
+	assert.Contains(suite.T(), payload, "payment_method_order_closed_at")
+	assert.Nil(suite.T(), payload["payment_method_order_closed_at"])
+}
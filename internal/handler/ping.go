@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+)
+
+const (
+	eventNamePing = "webhook.ping"
+
+	errorTestEventNotAllowed    = "only payment.success can be sent as the test notification for this callback protocol"
+	errorTestProtocolNotAllowed = "test notification can't be sent by the cardpay callback protocol"
+
+	sampleOrderId             = "00000000-0000-4000-8000-000000000000"
+	sampleOrderProjectOrderId = "test-order"
+	sampleOrderAccount        = "test-account"
+)
+
+// TestResult describes result of the test notification sent to the project endpoints
+type TestResult struct {
+	ProjectId string `json:"project_id"`
+	Protocol  string `json:"protocol"`
+	Event     string `json:"event"`
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+	// Requests sent to the project endpoints with the responses
+	Attempts []*DeliveryAttempt `json:"attempts"`
+	// Descriptions of the signature verification on the project side
+	Hints []string `json:"hints"`
+}
+
+// NewSampleOrder returns the fixture order of the project completed by the bank card payment.
+// It is used as the object of the test notifications and is never stored. The order has no country
+// and zero tax, so it's never reported to TaxJar.
+func NewSampleOrder(project *billingpb.ProjectOrder) *billingpb.Order {
+	now := ptypes.TimestampNow()
+
+	return &billingpb.Order{
+		Id:                 sampleOrderId,
+		Uuid:               sampleOrderId,
+		Transaction:        sampleOrderId,
+		Object:             "order",
+		Status:             recurringpb.OrderPublicStatusProcessed,
+		PrivateStatus:      recurringpb.OrderStatusProjectComplete,
+		Description:        "Test payment",
+		ProjectOrderId:     sampleOrderProjectOrderId,
+		ProjectAccount:     sampleOrderAccount,
+		OrderAmount:        10,
+		TotalPaymentAmount: 10,
+		Currency:           "USD",
+		Tax:                &billingpb.OrderTax{Currency: "USD"},
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		Project:            project,
+		User: &billingpb.OrderUser{
+			Id:     sampleOrderAccount,
+			Object: "user",
+			Email:  "test@example.com",
+			Ip:     "127.0.0.1",
+			Locale: "en-US",
+		},
+		PaymentMethod: &billingpb.PaymentMethodOrder{
+			Name:  "Bank card",
+			Group: recurringpb.PaymentSystemGroupAliasBankCard,
+		},
+		PaymentMethodOrderClosedAt: now,
+		PaymentMethodPayerAccount:  "400000******0002",
+		PaymentMethodTxnParams: map[string]string{
+			"is_3ds":           "false",
+			"rrn":              "000000000000",
+			"card_holder":      "TEST HOLDER",
+			"emission_country": "US",
+			"token":            "",
+		},
+	}
+}
+
+// SetTest marks the handler for the test notification: it is sent like the manual resend, but nothing
+// is stored: neither the notification stat, the order nor the delivery attempts are updated.
+// Event is webhook.ping or payment.success by default; the ping is sent only by callback protocols
+// with events of the default protocol. CardPay endpoints process the card data of the notification,
+// so the test notification isn't sent to them.
+func (h *Handler) SetTest(event string) error {
+	if event == "" {
+		event = eventNameSuccess
+	}
+
+	if h.order.GetProject().GetCallbackProtocol() == notifierHandlerCardPay {
+		return errors.New(errorTestProtocolNotAllowed)
+	}
+
+	if isDefaultEventsProtocol(h.order.GetProject().GetCallbackProtocol()) {
+		if event != eventNamePing && !isKnownEventName(event) {
+			return errors.New(errorResendEventUnknown)
+		}
+	} else if event != eventNameSuccess {
+		return errors.New(errorTestEventNotAllowed)
+	} else {
+		event = ""
+	}
+
+	// without redis the test notification changes neither delivery attempts nor state of the breakers,
+	// the rate limits and the endpoints
+	h.redis = nil
+	h.test = true
+	h.resend = true
+	h.resendEvent = event
+
+	return nil
+}
+
+// GetTestResult returns result of the test notification by error returned from notifier
+func (h *Handler) GetTestResult(err error) *TestResult {
+	res := h.GetResendResult(err)
+	result := &TestResult{
+		ProjectId: h.order.GetProject().GetId(),
+		Protocol:  res.Protocol,
+		Event:     res.Event,
+		Delivered: res.Delivered,
+		Error:     res.Error,
+		Attempts:  h.attempts,
+		Hints:     h.getSignatureHints(),
+	}
+
+	if result.Event == "" {
+		result.Event = eventNameSuccess
+	}
+
+	if result.Attempts == nil {
+		result.Attempts = []*DeliveryAttempt{}
+	}
+
+	return result
+}
+
+// getSignatureHints describes how the project verifies the signature of the notification request
+func (h *Handler) getSignatureHints() []string {
+	hints := []string{}
+
+	switch h.order.GetProject().GetCallbackProtocol() {
+	case notifierHandlerDefault, notifierHandlerCloudEvents:
+		n := &Default{Handler: h}
+
+		if n.getSignatureScheme() == SignatureSchemeLegacy {
+			hints = append(hints, fmt.Sprintf(
+				"%s header is \"Signature \" followed by hex encoded SHA256 of the raw request body "+
+					"concatenated with the secret key",
+				HeaderAuthorization,
+			))
+		} else {
+			hints = append(hints, fmt.Sprintf(
+				"%s header is \"%s=\" followed by hex encoded HMAC-SHA256 of \"<%s header>.<raw request body>\" "+
+					"with the secret key",
+				HeaderPaySuperSignature, signatureVersionV1, HeaderPaySuperTimestamp,
+			))
+			hints = append(hints, fmt.Sprintf("%s header is unix time of the request, reject stale requests",
+				HeaderPaySuperTimestamp))
+		}
+
+		if len(h.cfg.GetProject(h.order.GetProject().GetId()).Endpoints) > 0 {
+			hints = append(hints, "endpoints with own secret key are signed with that key")
+		}
+	case notifierHandlerStandardWebhooks:
+		hints = append(hints, fmt.Sprintf(
+			"%s header is \"%s,\" followed by base64 encoded HMAC-SHA256 of "+
+				"\"<%s header>.<%s header>.<raw request body>\" with the secret key",
+			HeaderWebhookSignature, standardWebhooksSignatureV1, HeaderWebhookId, HeaderWebhookTimestamp,
+		))
+		hints = append(hints, fmt.Sprintf(
+			"Standard Webhooks libraries verify it with the secret \"%s\" followed by base64 of the secret key, "+
+				"secret key already in that format is used as is",
+			standardWebhooksSecretPrefix,
+		))
+	case notifierHandlerTemplate:
+		hints = append(hints, getTemplateSignatureHints(h.cfg.GetProject(h.order.GetProject().GetId()).Template)...)
+	case notifierHandlerXSolla:
+		hints = append(hints, fmt.Sprintf(
+			"%s header is \"Signature \" followed by hex encoded SHA1 of the raw request body "+
+				"concatenated with the secret key",
+			HeaderAuthorization,
+		))
+	}
+
+	return hints
+}
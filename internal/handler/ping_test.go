@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	billMocks "github.com/paysuper/paysuper-proto/go/billingpb/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type PingTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	bs      *billMocks.BillingService
+	handler *Handler
+}
+
+func Test_Ping(t *testing.T) {
+	suite.Run(t, new(PingTestSuite))
+}
+
+func (suite *PingTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerDefault)
+	suite.handler.order = NewSampleOrder(suite.handler.order.Project)
+	suite.handler.cfg.SignatureScheme = SignatureSchemeHmacSha256
+	suite.redis = suite.handler.redis
+	suite.bs = suite.handler.repository.(*billMocks.BillingService)
+}
+
+func (suite *PingTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *PingTestSuite) TestPing_Ping_Ok() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		msg := make(map[string]interface{})
+		assert.NoError(suite.T(), json.Unmarshal(b, &msg))
+		assert.Equal(suite.T(), eventNamePing, msg["event"])
+		assert.NotContains(suite.T(), msg, "object")
+		assert.NotEmpty(suite.T(), req.Header.Get(HeaderPaySuperSignature))
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	err := suite.handler.SetTest(eventNamePing)
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetTestResult(newDefaultHandler(suite.handler).Notify())
+	assert.True(suite.T(), result.Delivered)
+	assert.Equal(suite.T(), eventNamePing, result.Event)
+	assert.Len(suite.T(), result.Attempts, 1)
+	assert.Equal(suite.T(), http.StatusOK, result.Attempts[0].ResponseStatus)
+	assert.NotEmpty(suite.T(), result.Hints)
+}
+
+func (suite *PingTestSuite) TestPing_SampleOrder_NothingStored() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		msg := make(map[string]interface{})
+		assert.NoError(suite.T(), json.Unmarshal(b, &msg))
+		assert.Equal(suite.T(), eventNameSuccess, msg["event"])
+		assert.Equal(suite.T(), sampleOrderId, msg["object"].(map[string]interface{})["id"])
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	err := suite.handler.SetTest("")
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetTestResult(newDefaultHandler(suite.handler).Notify())
+	assert.True(suite.T(), result.Delivered)
+	assert.Equal(suite.T(), eventNameSuccess, result.Event)
+
+	keys, err := suite.redis.Keys("*").Result()
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), keys)
+	suite.bs.AssertNumberOfCalls(suite.T(), "UpdateOrder", 0)
+}
+
+func (suite *PingTestSuite) TestPing_StandardWebhooks_NoSecret() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerStandardWebhooks
+	assert.NoError(suite.T(), suite.handler.SetTest(eventNamePing))
+
+	result := suite.handler.GetTestResult(newDefaultHandler(suite.handler).Notify())
+	assert.True(suite.T(), result.Delivered)
+	assert.NotEmpty(suite.T(), result.Hints)
+
+	b, err := json.Marshal(result)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(b), "VW5pdCBUZXN0")
+	assert.NotContains(suite.T(), string(b), suite.handler.order.Project.SecretKey)
+}
+
+func (suite *PingTestSuite) TestPing_Gone_NotRetired() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusGone, ""))
+
+	err := suite.handler.SetTest(eventNamePing)
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetTestResult(newDefaultHandler(suite.handler).Notify())
+	assert.False(suite.T(), result.Delivered)
+	assert.NotEmpty(suite.T(), result.Error)
+
+	retired, err := IsEndpointRetired(suite.redis, suite.handler.order.GetProject().GetId(), processUrl)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), retired)
+}
+
+func (suite *PingTestSuite) TestPing_SetTest_Error() {
+	err := suite.handler.SetTest("payment.unknown")
+	assert.EqualError(suite.T(), err, errorResendEventUnknown)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerXSolla
+	err = suite.handler.SetTest(eventNamePing)
+	assert.EqualError(suite.T(), err, errorTestEventNotAllowed)
+
+	err = suite.handler.SetTest(eventNameSuccess)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), suite.handler.resendEvent)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerCardPay
+	err = suite.handler.SetTest(eventNameSuccess)
+	assert.EqualError(suite.T(), err, errorTestProtocolNotAllowed)
+}
+
+func (suite *PingTestSuite) TestPing_TaxJar_Skipped() {
+	assert.NotNil(suite.T(), suite.handler.order.Tax)
+
+	// without the taxjar broker the report of the order would panic
+	suite.handler.order.Country = CountryCodeUSA
+	suite.handler.order.Tax.Rate = 0.1
+
+	assert.NoError(suite.T(), suite.handler.SetTest(eventNameSuccess))
+	assert.NotPanics(suite.T(), suite.handler.trySendToTaxJar)
+}
+
+func (suite *PingTestSuite) TestPing_getSignatureHints() {
+	hints := suite.handler.getSignatureHints()
+	assert.Contains(suite.T(), hints[0], HeaderPaySuperSignature)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerXSolla
+	hints = suite.handler.getSignatureHints()
+	assert.Len(suite.T(), hints, 1)
+	assert.Contains(suite.T(), hints[0], fmt.Sprintf("%s header", HeaderAuthorization))
+}
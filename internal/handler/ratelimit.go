@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/metrics"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// Every bucket has own hash tag, so the buckets are spread over the slots of Redis Cluster
+	// and the script takes tokens from one bucket at a time
+	rateLimitGlobalKey      = "ps:ratelimit:{global}"
+	rateLimitProjectKeyMask = "ps:ratelimit:{project:%s}"
+	rateLimitHostKeyMask    = "ps:ratelimit:{host:%s}"
+
+	RateLimitScopeGlobal  = "global"
+	RateLimitScopeProject = "project"
+	RateLimitScopeHost    = "host"
+
+	LoggerRateLimitRedis = "Rate limit bucket in redis failed"
+)
+
+// ErrRateLimited is returned instead of the http request when the delivery exceeds the outbound rate limits
+var ErrRateLimited = errors.New("outbound rate limit exceeded")
+
+// rateLimitScript takes the count of tokens from the bucket only if it has them, negative count returns tokens
+// to the bucket. Arguments are the current time in milliseconds, rate in tokens per second, burst size and
+// the count. Returns the wait time in seconds until the bucket has the tokens, zero if they are taken.
+var rateLimitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local count = tonumber(ARGV[4])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or burst
+local ts = tonumber(bucket[2]) or now
+local wait = 0
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+
+if tokens < count then
+	wait = (count - tokens) / rate
+else
+	tokens = math.min(burst, tokens - count)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('EXPIRE', KEYS[1], math.ceil(burst / rate) + 1)
+
+return tostring(wait)
+`)
+
+type rateLimitBucket struct {
+	scope string
+	key   string
+	limit *config.RateLimit
+}
+
+// getRateLimitBuckets returns token buckets of the request: global, of the order project and of the url host.
+// Buckets with zero rate are disabled.
+func (h *Handler) getRateLimitBuckets(reqUrl string) []*rateLimitBucket {
+	var buckets []*rateLimitBucket
+
+	add := func(scope, key string, limit *config.RateLimit) {
+		if limit != nil && limit.Rate > 0 {
+			buckets = append(buckets, &rateLimitBucket{scope: scope, key: key, limit: limit})
+		}
+	}
+
+	projectId := h.order.GetProject().GetId()
+	add(RateLimitScopeGlobal, rateLimitGlobalKey, h.cfg.GetGlobalRateLimit())
+	add(RateLimitScopeProject, fmt.Sprintf(rateLimitProjectKeyMask, projectId), h.cfg.GetProjectRateLimit(projectId))
+
+	if u, err := url.Parse(reqUrl); err == nil && u.Host != "" {
+		add(RateLimitScopeHost, fmt.Sprintf(rateLimitHostKeyMask, u.Host), h.cfg.GetHostRateLimit())
+	}
+
+	return buckets
+}
+
+// rateLimit takes a token from the rate limit buckets of the request. If any bucket is empty
+// ErrRateLimited is returned, tokens taken from the other buckets are returned and the next try is delayed
+// until the bucket is refilled. Manual resend isn't limited.
+func (h *Handler) rateLimit(reqUrl string) error {
+	if h.redis == nil || h.cfg == nil || h.resend {
+		return nil
+	}
+
+	buckets := h.getRateLimitBuckets(reqUrl)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for i, b := range buckets {
+		wait, err := b.take(h.redis, now, 1)
+
+		if err != nil {
+			// rate limits must not block deliveries when redis is unavailable
+			h.HandleError(LoggerRateLimitRedis, err, nil)
+			return nil
+		}
+
+		if wait <= 0 {
+			continue
+		}
+
+		for _, taken := range buckets[:i] {
+			if _, err := taken.take(h.redis, now, -1); err != nil {
+				h.HandleError(LoggerRateLimitRedis, err, nil)
+			}
+		}
+
+		h.retryAfter = int32(math.Max(1, math.Ceil(wait)))
+		metrics.RateLimitedTotal.WithLabelValues(h.order.GetProject().GetCallbackProtocol(), b.scope).Inc()
+
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// take takes the count of tokens from the bucket and returns the wait time in seconds if the bucket doesn't have them
+func (b *rateLimitBucket) take(rdb *redis.Client, now int64, count int) (float64, error) {
+	burst := b.limit.Burst
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	res, err := rateLimitScript.Run(rdb, []string{b.key}, now, b.limit.Rate, burst, count).Result()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(fmt.Sprint(res), 64)
+}
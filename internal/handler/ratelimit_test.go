@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type RateLimitTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_RateLimit(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
+
+func (suite *RateLimitTestSuite) SetupTest() {
+	cfg, err := config.NewConfig()
+	assert.NoError(suite.T(), err)
+
+	suite.redis = redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisHost,
+		Password: cfg.RedisPassword,
+	})
+
+	_, err = suite.redis.Ping().Result()
+	assert.NoError(suite.T(), err)
+
+	cfg.RateLimitGlobal = 0
+	cfg.RateLimitProject = 0.5
+	cfg.RateLimitProjectBurst = 2
+	cfg.RateLimitHost = 100
+	cfg.RateLimitHostBurst = 100
+
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id: "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{
+				Id:               "254e3736-000f-5000-8000-178d1d80bf71",
+				CallbackProtocol: "default",
+			},
+		},
+		redis:      suite.redis,
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (suite *RateLimitTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_request_Delayed() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	for i := 0; i < 2; i++ {
+		_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+		assert.NoError(suite.T(), err)
+	}
+
+	_, err := suite.handler.request(http.MethodPost, processUrl, []byte("{}"), nil)
+	assert.True(suite.T(), errors.Is(err, ErrRateLimited))
+	assert.True(suite.T(), suite.handler.retryAfter >= 1 && suite.handler.retryAfter <= 2)
+	assert.Equal(suite.T(), 2, httpmock.GetTotalCallCount())
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_HostLimit() {
+	suite.handler.cfg.RateLimitProject = 0
+	suite.handler.cfg.RateLimitHost = 1
+	suite.handler.cfg.RateLimitHostBurst = 1
+
+	assert.NoError(suite.T(), suite.handler.rateLimit(processUrl))
+	assert.True(suite.T(), errors.Is(suite.handler.rateLimit(processUrl), ErrRateLimited))
+
+	suite.handler.order.Project.Id = "254e3736-000f-5000-8000-178d1d80bf72"
+	assert.True(suite.T(), errors.Is(suite.handler.rateLimit(processUrl), ErrRateLimited))
+	assert.NoError(suite.T(), suite.handler.rateLimit("http://example.com/process"))
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_ProjectOverride() {
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {RateLimit: &config.RateLimit{Rate: 100, Burst: 5}},
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(suite.T(), suite.handler.rateLimit(processUrl))
+	}
+
+	assert.True(suite.T(), errors.Is(suite.handler.rateLimit(processUrl), ErrRateLimited))
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_NotConsumedWhenLimited() {
+	suite.handler.cfg.RateLimitHost = 1
+	suite.handler.cfg.RateLimitHostBurst = 1
+	suite.handler.cfg.RateLimitProjectBurst = 3
+
+	assert.NoError(suite.T(), suite.handler.rateLimit(processUrl))
+	assert.True(suite.T(), errors.Is(suite.handler.rateLimit(processUrl), ErrRateLimited))
+
+	tokens, err := suite.redis.HGet(fmt.Sprintf(rateLimitProjectKeyMask, suite.handler.order.Project.Id), "tokens").Float64()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), tokens >= 2)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_getRateLimitBuckets_HashTags() {
+	suite.handler.cfg.RateLimitGlobal = 100
+	buckets := suite.handler.getRateLimitBuckets(processUrl)
+	assert.Len(suite.T(), buckets, 3)
+
+	// every bucket is stored in own slot of Redis Cluster
+	tags := map[string]bool{}
+
+	for _, b := range buckets {
+		start, end := strings.Index(b.key, "{"), strings.Index(b.key, "}")
+		assert.True(suite.T(), start >= 0 && end > start+1)
+		tags[b.key[start+1:end]] = true
+	}
+
+	assert.Len(suite.T(), tags, 3)
+}
+
+func (suite *RateLimitTestSuite) TestRateLimit_Resend_NotLimited() {
+	suite.handler.resend = true
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(suite.T(), suite.handler.rateLimit(processUrl))
+	}
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+)
+
+const (
+	errorResendEventUnknown    = "unknown event name"
+	errorResendEventNotAllowed = "event can be specified only for callback protocols with events of default protocol"
+)
+
+// ResendResult describes result of the manual notification resend
+type ResendResult struct {
+	OrderId   string            `json:"order_id"`
+	Protocol  string            `json:"protocol"`
+	Event     string            `json:"event,omitempty"`
+	Delivered bool              `json:"delivered"`
+	Error     string            `json:"error,omitempty"`
+	Attempt   *DeliveryAttempt  `json:"attempt,omitempty"`
+	Endpoints []*EndpointResult `json:"endpoints,omitempty"`
+}
+
+// EndpointResult describes delivery result of the notification to a single project endpoint
+type EndpointResult struct {
+	Url       string           `json:"url"`
+	Delivered bool             `json:"delivered"`
+	Error     string           `json:"error,omitempty"`
+	Attempt   *DeliveryAttempt `json:"attempt,omitempty"`
+}
+
+// SetResend marks the handler for manual resend: the sent notification mark is ignored and no retries are scheduled.
+// If event is not empty it is sent instead of the event of the current order status.
+func (h *Handler) SetResend(event string) error {
+	if event != "" {
+		if !isDefaultEventsProtocol(h.order.GetProject().GetCallbackProtocol()) {
+			return errors.New(errorResendEventNotAllowed)
+		}
+
+		if !isKnownEventName(event) {
+			return errors.New(errorResendEventUnknown)
+		}
+	}
+
+	h.resend = true
+	h.resendEvent = event
+
+	return nil
+}
+
+// GetResendResult returns result of the manual resend by error returned from notifier
+func (h *Handler) GetResendResult(err error) *ResendResult {
+	result := &ResendResult{
+		OrderId:   h.order.GetId(),
+		Protocol:  h.order.GetProject().GetCallbackProtocol(),
+		Event:     h.resendEvent,
+		Attempt:   h.lastAttempt,
+		Endpoints: h.endpointResults,
+	}
+
+	// failure of any endpoint fails the resend, not only the failure of the last one
+	for _, e := range h.endpointResults {
+		if err == nil && !e.Delivered {
+			err = errors.New(e.Error)
+		}
+	}
+
+	if err == nil {
+		err = h.lastError
+	}
+
+	if err == nil && h.lastAttempt != nil && h.lastAttempt.Error != "" {
+		err = errors.New(h.lastAttempt.Error)
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Delivered = true
+
+	return result
+}
+
+// addEndpointResult records delivery result of the endpoint, the attempt is set only when the http request was sent
+func (h *Handler) addEndpointResult(url string, attempts int, err error) {
+	result := &EndpointResult{Url: url, Delivered: err == nil}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if len(h.attempts) > attempts {
+		result.Attempt = h.attempts[len(h.attempts)-1]
+	}
+
+	h.endpointResults = append(h.endpointResults, result)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type ResendTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_Resend(t *testing.T) {
+	suite.Run(t, new(ResendTestSuite))
+}
+
+func (suite *ResendTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerDefault)
+	suite.handler.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
+	suite.redis = suite.handler.redis
+}
+
+func (suite *ResendTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *ResendTestSuite) TestResend_AlreadySent_Ok() {
+	ps := suite.handler.order.GetPublicStatus()
+	err := suite.handler.setStat(fmt.Sprintf(psNotificationsKeyMask, suite.handler.order.Id), ps, true)
+	assert.NoError(suite.T(), err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	err = suite.handler.SetResend("")
+	assert.NoError(suite.T(), err)
+
+	err = newDefaultHandler(suite.handler).Notify()
+	result := suite.handler.GetResendResult(err)
+
+	assert.True(suite.T(), result.Delivered)
+	assert.Empty(suite.T(), result.Error)
+	assert.NotNil(suite.T(), result.Attempt)
+	assert.Equal(suite.T(), http.StatusOK, result.Attempt.ResponseStatus)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *ResendTestSuite) TestResend_EventOverride_Ok() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		msg := make(map[string]interface{})
+		assert.NoError(suite.T(), json.Unmarshal(b, &msg))
+		assert.Equal(suite.T(), eventNameRefund, msg["event"])
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	err := suite.handler.SetResend(eventNameRefund)
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetResendResult(newDefaultHandler(suite.handler).Notify())
+	assert.True(suite.T(), result.Delivered)
+	assert.Equal(suite.T(), eventNameRefund, result.Event)
+}
+
+func (suite *ResendTestSuite) TestResend_Failed_NoRetry() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+
+	err := suite.handler.SetResend("")
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetResendResult(newDefaultHandler(suite.handler).Notify())
+	assert.False(suite.T(), result.Delivered)
+	assert.NotEmpty(suite.T(), result.Error)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+}
+
+func (suite *ResendTestSuite) TestResend_Endpoints_FirstFailed() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", refundUrl, httpmock.NewStringResponder(http.StatusInternalServerError, ""))
+	httpmock.RegisterResponder("POST", processUrl, httpmock.NewStringResponder(http.StatusOK, ""))
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {
+			Endpoints: []*config.Endpoint{{Url: refundUrl}, {Url: processUrl}},
+		},
+	}
+
+	err := suite.handler.SetResend("")
+	assert.NoError(suite.T(), err)
+
+	result := suite.handler.GetResendResult(newDefaultHandler(suite.handler).Notify())
+	assert.False(suite.T(), result.Delivered)
+	assert.NotEmpty(suite.T(), result.Error)
+	assert.Len(suite.T(), result.Endpoints, 2)
+
+	assert.Equal(suite.T(), refundUrl, result.Endpoints[0].Url)
+	assert.False(suite.T(), result.Endpoints[0].Delivered)
+	assert.NotEmpty(suite.T(), result.Endpoints[0].Error)
+	assert.NotNil(suite.T(), result.Endpoints[0].Attempt)
+
+	assert.Equal(suite.T(), processUrl, result.Endpoints[1].Url)
+	assert.True(suite.T(), result.Endpoints[1].Delivered)
+	assert.Empty(suite.T(), result.Endpoints[1].Error)
+	assert.Equal(suite.T(), http.StatusOK, result.Endpoints[1].Attempt.ResponseStatus)
+}
+
+func (suite *ResendTestSuite) TestResend_SetResend_Error() {
+	err := suite.handler.SetResend("unknown.event")
+	assert.EqualError(suite.T(), err, errorResendEventUnknown)
+	assert.False(suite.T(), suite.handler.resend)
+
+	suite.handler.order.Project.CallbackProtocol = notifierHandlerXSolla
+	err = suite.handler.SetResend(eventNameSuccess)
+	assert.EqualError(suite.T(), err, errorResendEventNotAllowed)
+
+	err = suite.handler.SetResend("")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.resend)
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/streadway/amqp"
+	rabbitmq "gopkg.in/ProtocolONE/rabbitmq.v1/pkg"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryExchangeNameMask = "%s-%d"
+)
+
+// RetryBrokers contains brokers of the retry delay queues keyed by the queue message ttl in seconds
+type RetryBrokers map[int32]rabbitmq.BrokerInterface
+
+// GetRetryExchangeName returns name of the retry exchange for delay queue with specified ttl in seconds
+func GetRetryExchangeName(delay int32) string {
+	return fmt.Sprintf(retryExchangeNameMask, RetryExchangeName, delay)
+}
+
+// IsFirstProcessing checks that the notification is processed for the first time, not retried or deferred.
+// Deferred notification keeps the retry count, so the count alone doesn't tell the first processing.
+func (h *Handler) IsFirstProcessing() bool {
+	if h.RetryCount > 0 {
+		return false
+	}
+
+	_, ok := h.dlv.Headers[retryFirstAttemptHeader]
+
+	return !ok
+}
+
+// getRetryPolicy returns retry policy resolved for the order project.
+// Without resolved policy the legacy flat retry with RetryMaxCount tries is used.
+func (h *Handler) getRetryPolicy() *config.RetryPolicy {
+	if h.retryPolicy == nil {
+		return &config.RetryPolicy{MaxCount: RetryMaxCount}
+	}
+
+	return h.retryPolicy
+}
+
+// canRetry checks that the notification not exceeded neither retry count nor retry age limit of the project.
+// Time the delivery was deferred by the circuit breaker or the rate limits isn't counted in the retry age.
+func (h *Handler) canRetry() bool {
+	policy := h.getRetryPolicy()
+
+	if h.RetryCount >= policy.MaxCount {
+		return false
+	}
+
+	if policy.MaxAge > 0 && h.retryFirstAttemptAt > 0 &&
+		time.Now().Unix()-h.retryFirstAttemptAt-h.retryDeferred >= policy.MaxAge {
+		return false
+	}
+
+	return true
+}
+
+// getRetryDelay calculates delay in seconds before the next delivery try by exponential backoff with jitter.
+// Without backoff settings the legacy flat delay is returned.
+func (h *Handler) getRetryDelay() int32 {
+	policy := h.getRetryPolicy()
+
+	if policy.BackoffBase <= 0 {
+		return RetryDlxTimeout
+	}
+
+	// the power overflows to +Inf for a large retry count, so the delay is always clamped
+	maxDelay := float64(math.MaxInt32)
+
+	if policy.BackoffMax > 0 {
+		maxDelay = float64(policy.BackoffMax)
+	}
+
+	delay := math.Min(float64(policy.BackoffBase)*math.Pow(policy.BackoffFactor, float64(h.RetryCount)), maxDelay)
+
+	if policy.BackoffJitter > 0 {
+		delay += delay * policy.BackoffJitter * (2*rand.Float64() - 1)
+	}
+
+	return int32(math.Round(math.Min(delay, math.MaxInt32)))
+}
+
+// getRetryBroker returns the delay queue broker with the largest ttl not exceeding the requested delay, the queue
+// with the smallest ttl if all of them exceed it. The rest of the delay is waited by postponing the notification
+// when it comes back from the queue, so the delay isn't rounded to the queue ttl.
+func (h *Handler) getRetryBroker(delay int32) (int32, rabbitmq.BrokerInterface) {
+	var (
+		ttl    int32
+		broker rabbitmq.BrokerInterface
+	)
+
+	for k, v := range h.retryBrokers {
+		if broker == nil || (k <= delay && (ttl > delay || k > ttl)) || (k > delay && ttl > delay && k < ttl) {
+			ttl, broker = k, v
+		}
+	}
+
+	return ttl, broker
+}
+
+// getNextRetryBroker returns delay in seconds before the next delivery try and the delay queue broker for it.
+// Delay requested by the project with Retry-After header takes precedence over the backoff, the notification
+// is postponed until the requested time even if it is longer than ttl of all delay queues.
+func (h *Handler) getNextRetryBroker() (int32, int32, rabbitmq.BrokerInterface) {
+	delay := h.retryAfter
+
+	if delay <= 0 {
+		delay = h.getRetryDelay()
+	}
+
+	ttl, broker := h.getRetryBroker(delay)
+
+	return delay, ttl, broker
+}
+
+// Postpone returns the notification which came from the delay queue earlier than its next delivery try back to
+// the delay queues, so the next try is never made earlier than it was scheduled. Returns true if the notification is postponed and mustn't be processed now.
+func (h *Handler) Postpone() (bool, error) {
+	notBefore := getRetryNotBefore(h.dlv)
+
+	if notBefore <= 0 {
+		return false, nil
+	}
+
+	remaining := notBefore - time.Now().Unix()
+
+	if remaining <= 0 {
+		return false, nil
+	}
+
+	if remaining > math.MaxInt32 {
+		remaining = math.MaxInt32
+	}
+
+	ttl, broker := h.getRetryBroker(int32(remaining))
+
+	if broker == nil {
+		return false, errors.New(errorRetryBrokerNotFound)
+	}
+
+	headers := amqp.Table{}
+
+	for k, v := range h.dlv.Headers {
+		headers[k] = v
+	}
+
+	if err := broker.Publish(h.dlv.RoutingKey, h.order, headers); err != nil {
+		h.HandleError(loggerErrorNotificationRetryFailed, err, Table{"retry_count": h.RetryCount, "retry_delay": ttl})
+		return false, err
+	}
+
+	return true, nil
+}
+
+// getRetryAfter returns delay in seconds requested by Retry-After header in seconds or http date format
+func getRetryAfter(header http.Header, now time.Time) int32 {
+	v := strings.TrimSpace(header.Get(HeaderRetryAfter))
+
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseInt(v, 10, 32); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return int32(seconds)
+	}
+
+	t, err := http.ParseTime(v)
+
+	if err != nil || !t.After(now) {
+		return 0
+	}
+
+	return int32(math.Ceil(t.Sub(now).Seconds()))
+}
+
+// getRetryFirstAttemptAt returns unix time of the first retry of the notification from delivery headers
+func getRetryFirstAttemptAt(dlv amqp.Delivery) int64 {
+	return getInt64Header(dlv, retryFirstAttemptHeader)
+}
+
+// getRetryDeferred returns seconds the notification was deferred by the circuit breaker or the rate limits
+func getRetryDeferred(dlv amqp.Delivery) int64 {
+	return getInt64Header(dlv, retryDeferredHeader)
+}
+
+// getRetryNotBefore returns unix time of the next delivery try of the notification from delivery headers
+func getRetryNotBefore(dlv amqp.Delivery) int64 {
+	return getInt64Header(dlv, retryNotBeforeHeader)
+}
+
+func getInt64Header(dlv amqp.Delivery, name string) int64 {
+	v, ok := dlv.Headers[name]
+
+	if !ok {
+		return 0
+	}
+
+	switch t := v.(type) {
+	case int64:
+		return t
+	case int32:
+		return int64(t)
+	}
+
+	return 0
+}
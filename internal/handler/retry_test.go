@@ -0,0 +1,391 @@
+package handler
+
+import (
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/mock"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+	handler *Handler
+}
+
+func Test_Retry(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
+
+func (suite *RetryTestSuite) SetupTest() {
+	suite.handler = &Handler{
+		order: &billingpb.Order{
+			Id:      "254e3736-000f-5000-8000-178d1d80bf70",
+			Project: &billingpb.ProjectOrder{Id: "254e3736-000f-5000-8000-178d1d80bf70"},
+		},
+		retryPolicy: &config.RetryPolicy{
+			MaxCount:      288,
+			BackoffBase:   5,
+			BackoffFactor: 2,
+			BackoffMax:    3600,
+		},
+		retryBrokers: RetryBrokers{
+			5:    mock.NewBrokerMockOk(),
+			30:   mock.NewBrokerMockOk(),
+			120:  mock.NewBrokerMockOk(),
+			600:  mock.NewBrokerMockOk(),
+			1800: mock.NewBrokerMockOk(),
+			3600: mock.NewBrokerMockOk(),
+		},
+	}
+}
+
+func (suite *RetryTestSuite) TearDownTest() {}
+
+func (suite *RetryTestSuite) TestRetry_getRetryDelay_Exponential() {
+	suite.handler.RetryCount = 0
+	assert.Equal(suite.T(), int32(5), suite.handler.getRetryDelay())
+
+	suite.handler.RetryCount = 3
+	assert.Equal(suite.T(), int32(40), suite.handler.getRetryDelay())
+
+	suite.handler.RetryCount = 200
+	assert.Equal(suite.T(), int32(3600), suite.handler.getRetryDelay())
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryDelay_Jitter() {
+	suite.handler.retryPolicy.BackoffJitter = 0.2
+	suite.handler.RetryCount = 200
+
+	for i := 0; i < 100; i++ {
+		delay := suite.handler.getRetryDelay()
+		assert.True(suite.T(), delay >= 2880 && delay <= 4320)
+	}
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryDelay_LargeRetryCount() {
+	suite.handler.retryPolicy.BackoffMax = 0
+	suite.handler.retryPolicy.BackoffJitter = 0.2
+	suite.handler.RetryCount = 100000
+
+	for i := 0; i < 100; i++ {
+		delay := suite.handler.getRetryDelay()
+		// the delay is clamped, the jitter applies to the clamped value
+		assert.True(suite.T(), delay >= math.MaxInt32/2)
+	}
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryDelay_WithoutBackoff() {
+	suite.handler.retryPolicy = nil
+	assert.Equal(suite.T(), int32(RetryDlxTimeout), suite.handler.getRetryDelay())
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryBroker() {
+	ttl, broker := suite.handler.getRetryBroker(1)
+	assert.Equal(suite.T(), int32(5), ttl)
+	assert.NotNil(suite.T(), broker)
+
+	ttl, _ = suite.handler.getRetryBroker(40)
+	assert.Equal(suite.T(), int32(30), ttl)
+
+	ttl, _ = suite.handler.getRetryBroker(1000)
+	assert.Equal(suite.T(), int32(600), ttl)
+
+	ttl, _ = suite.handler.getRetryBroker(100000)
+	assert.Equal(suite.T(), int32(3600), ttl)
+
+	suite.handler.retryBrokers = nil
+	_, broker = suite.handler.getRetryBroker(5)
+	assert.Nil(suite.T(), broker)
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_PublishToTier() {
+	suite.handler.RetryCount = 2
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_MaxCountReached() {
+	suite.handler.cfg = &config.Config{}
+	suite.handler.centrifugoDashboard = NewCentrifugo(&config.Centrifugo{}, mock.NewCentrifugoTransportStatusOk())
+	suite.handler.retryPolicy.MaxCount = 3
+	suite.handler.RetryCount = 3
+
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+}
+
+func (suite *RetryTestSuite) TestRetry_canRetry() {
+	suite.handler.retryPolicy.MaxCount = 3
+	suite.handler.RetryCount = 2
+	assert.True(suite.T(), suite.handler.canRetry())
+
+	suite.handler.RetryCount = 3
+	assert.False(suite.T(), suite.handler.canRetry())
+
+	suite.handler.RetryCount = 0
+	suite.handler.retryPolicy.MaxAge = 3600
+	suite.handler.retryFirstAttemptAt = time.Now().Unix() - 60
+	assert.True(suite.T(), suite.handler.canRetry())
+
+	suite.handler.retryFirstAttemptAt = time.Now().Unix() - 3600
+	assert.False(suite.T(), suite.handler.canRetry())
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryFirstAttemptAt() {
+	assert.Equal(suite.T(), int64(0), getRetryFirstAttemptAt(amqp.Delivery{}))
+	assert.Equal(suite.T(), int64(100), getRetryFirstAttemptAt(amqp.Delivery{Headers: amqp.Table{retryFirstAttemptHeader: int64(100)}}))
+	assert.Equal(suite.T(), int64(100), getRetryFirstAttemptAt(amqp.Delivery{Headers: amqp.Table{retryFirstAttemptHeader: int32(100)}}))
+}
+
+func (suite *RetryTestSuite) TestRetry_GetRetryPolicy_ProjectOverrides() {
+	projectId := suite.handler.order.Project.Id
+	cfg := &config.Config{
+		RetryMaxCount:      288,
+		RetryMaxAge:        172800,
+		RetryTestMaxCount:  5,
+		RetryTestMaxAge:    3600,
+		RetryBackoffBase:   5,
+		RetryBackoffFactor: 2,
+		RetryBackoffMax:    3600,
+	}
+
+	policy := cfg.GetRetryPolicy(projectId, true)
+	assert.Equal(suite.T(), int32(288), policy.MaxCount)
+	assert.Equal(suite.T(), int64(172800), policy.MaxAge)
+
+	policy = cfg.GetRetryPolicy(projectId, false)
+	assert.Equal(suite.T(), int32(5), policy.MaxCount)
+	assert.Equal(suite.T(), int64(3600), policy.MaxAge)
+
+	cfg.Projects = config.Projects{
+		projectId: {
+			Retry:     &config.RetryPolicy{MaxAge: 259200, BackoffMax: 7200},
+			RetryTest: &config.RetryPolicy{MaxCount: 2},
+		},
+	}
+
+	policy = cfg.GetRetryPolicy(projectId, true)
+	assert.Equal(suite.T(), int32(288), policy.MaxCount)
+	assert.Equal(suite.T(), int64(259200), policy.MaxAge)
+	assert.Equal(suite.T(), int32(7200), policy.BackoffMax)
+	assert.Equal(suite.T(), int32(5), policy.BackoffBase)
+
+	policy = cfg.GetRetryPolicy(projectId, false)
+	assert.Equal(suite.T(), int32(2), policy.MaxCount)
+	assert.Equal(suite.T(), int64(3600), policy.MaxAge)
+	assert.Equal(suite.T(), int32(3600), policy.BackoffMax)
+}
+
+func (suite *RetryTestSuite) TestRetry_GetRetryExchangeName() {
+	assert.Equal(suite.T(), "notify-payment-retry-600", GetRetryExchangeName(600))
+}
+
+func (suite *RetryTestSuite) TestRetry_getNextRetryBroker_RetryAfter() {
+	suite.handler.retryAfter = 60
+	delay, ttl, broker := suite.handler.getNextRetryBroker()
+	assert.Equal(suite.T(), int32(60), delay)
+	assert.Equal(suite.T(), int32(30), ttl)
+	assert.NotNil(suite.T(), broker)
+
+	suite.handler.retryAfter = 30
+	delay, ttl, _ = suite.handler.getNextRetryBroker()
+	assert.Equal(suite.T(), int32(30), delay)
+	assert.Equal(suite.T(), int32(30), ttl)
+
+	// longer than ttl of all delay queues, the rest of the delay is waited by postponing
+	suite.handler.retryAfter = 86400
+	delay, ttl, _ = suite.handler.getNextRetryBroker()
+	assert.Equal(suite.T(), int32(86400), delay)
+	assert.Equal(suite.T(), int32(3600), ttl)
+
+	suite.handler.retryAfter = 0
+	suite.handler.RetryCount = 0
+	delay, ttl, _ = suite.handler.getNextRetryBroker()
+	assert.Equal(suite.T(), int32(5), delay)
+	assert.Equal(suite.T(), int32(5), ttl)
+}
+
+func (suite *RetryTestSuite) TestRetry_getNextRetryBroker_JitterKept() {
+	suite.handler.retryPolicy.BackoffJitter = 0.2
+	suite.handler.RetryCount = 4
+
+	for i := 0; i < 100; i++ {
+		delay, ttl, _ := suite.handler.getNextRetryBroker()
+		assert.True(suite.T(), delay >= 64 && delay <= 96)
+		assert.Equal(suite.T(), int32(30), ttl)
+	}
+}
+
+func (suite *RetryTestSuite) TestRetry_Postpone() {
+	postponed, err := suite.handler.Postpone()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), postponed)
+
+	suite.handler.dlv = amqp.Delivery{Headers: amqp.Table{retryNotBeforeHeader: time.Now().Unix() - 1}}
+	postponed, err = suite.handler.Postpone()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), postponed)
+
+	suite.handler.dlv = amqp.Delivery{Headers: amqp.Table{retryNotBeforeHeader: time.Now().Unix() + 2}}
+	postponed, err = suite.handler.Postpone()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), postponed)
+
+	suite.handler.dlv = amqp.Delivery{Headers: amqp.Table{retryNotBeforeHeader: time.Now().Unix() + 86400}}
+	postponed, err = suite.handler.Postpone()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), postponed)
+
+	suite.handler.retryBrokers = nil
+	postponed, err = suite.handler.Postpone()
+	assert.Error(suite.T(), err)
+	assert.False(suite.T(), postponed)
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryNotBefore() {
+	assert.Equal(suite.T(), int64(0), getRetryNotBefore(amqp.Delivery{}))
+	assert.Equal(suite.T(), int64(100), getRetryNotBefore(amqp.Delivery{Headers: amqp.Table{retryNotBeforeHeader: int64(100)}}))
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryAfter() {
+	now := time.Date(2019, 12, 23, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(suite.T(), int32(0), getRetryAfter(http.Header{}, now))
+	assert.Equal(suite.T(), int32(120), getRetryAfter(http.Header{HeaderRetryAfter: []string{"120"}}, now))
+	assert.Equal(suite.T(), int32(0), getRetryAfter(http.Header{HeaderRetryAfter: []string{"-1"}}, now))
+	assert.Equal(suite.T(), int32(0), getRetryAfter(http.Header{HeaderRetryAfter: []string{"soon"}}, now))
+
+	date := now.Add(90 * time.Second).Format(http.TimeFormat)
+	assert.Equal(suite.T(), int32(90), getRetryAfter(http.Header{HeaderRetryAfter: []string{date}}, now))
+
+	date = now.Add(-90 * time.Second).Format(http.TimeFormat)
+	assert.Equal(suite.T(), int32(0), getRetryAfter(http.Header{HeaderRetryAfter: []string{date}}, now))
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_EndpointRetired() {
+	parking := mock.NewParkingMockOk()
+	suite.handler.parking = parking
+	suite.handler.endpointRetired = true
+
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Len(suite.T(), parking.Parked, 1)
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_Expired() {
+	parking := mock.NewParkingMockOk()
+	suite.handler.parking = parking
+	suite.handler.cfg = &config.Config{NotificationTtl: 3600}
+	suite.handler.centrifugoDashboard = NewCentrifugo(&config.Centrifugo{}, mock.NewCentrifugoTransportStatusOk())
+	suite.handler.retryFirstAttemptAt = time.Now().Unix() - 3600
+
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+	assert.Len(suite.T(), parking.Parked, 0)
+}
+
+func (suite *RetryTestSuite) TestRetry_isExpired() {
+	now := time.Now()
+	suite.handler.retryFirstAttemptAt = now.Unix() - 60
+	assert.False(suite.T(), suite.handler.isExpired(now))
+	assert.True(suite.T(), suite.handler.getExpiresAt().IsZero())
+
+	suite.handler.cfg = &config.Config{NotificationTtl: 3600}
+	assert.False(suite.T(), suite.handler.isExpired(now))
+	assert.Equal(suite.T(), now.Unix()+3540, suite.handler.getExpiresAt().Unix())
+
+	suite.handler.cfg.Projects = config.Projects{
+		suite.handler.order.Project.Id: {NotificationTtl: 30},
+	}
+	assert.True(suite.T(), suite.handler.isExpired(now))
+
+	suite.handler.resend = true
+	assert.False(suite.T(), suite.handler.isExpired(now))
+}
+
+func (suite *RetryTestSuite) TestRetry_getExpiresAt_EventProducedAt() {
+	now := time.Now()
+	suite.handler.cfg = &config.Config{NotificationTtl: 3600}
+	suite.handler.retryFirstAttemptAt = now.Unix() - 60
+	suite.handler.order.UpdatedAt = &timestamp.Timestamp{Seconds: now.Unix() - 600}
+	assert.Equal(suite.T(), now.Unix()+3000, suite.handler.getExpiresAt().Unix())
+
+	suite.handler.order.UpdatedAt = &timestamp.Timestamp{Seconds: now.Unix() - 4000}
+	assert.True(suite.T(), suite.handler.isExpired(now))
+
+	suite.handler.dlv.Headers = GetReplayHeaders()
+	assert.Equal(suite.T(), int32(0), suite.handler.dlv.Headers[retryCountHeader])
+	assert.False(suite.T(), suite.handler.isExpired(now))
+	assert.InDelta(suite.T(), now.Unix()+3600, suite.handler.getExpiresAt().Unix(), 1)
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_RetryAfterLaterThanExpiry() {
+	suite.handler.cfg = &config.Config{NotificationTtl: 3600}
+	suite.handler.centrifugoDashboard = NewCentrifugo(&config.Centrifugo{}, mock.NewCentrifugoTransportStatusOk())
+	suite.handler.retryFirstAttemptAt = time.Now().Unix()
+	suite.handler.retryAfter = 7200
+
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.handler.retryProcess)
+
+	suite.handler.retryAfter = 1800
+
+	err = suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+}
+
+func (suite *RetryTestSuite) TestRetry_retry_DeferredNotCounted() {
+	suite.handler.cfg = &config.Config{NotificationTtl: 3600}
+	suite.handler.retryPolicy.MaxAge = 3600
+	suite.handler.retryFirstAttemptAt = time.Now().Unix() - 3700
+	suite.handler.retryDeferred = 600
+	suite.handler.RetryCount = 2
+	suite.handler.lastError = ErrRateLimited
+	suite.handler.retryAfter = 30
+
+	assert.True(suite.T(), suite.handler.canRetry())
+	assert.False(suite.T(), suite.handler.isExpired(time.Now()))
+
+	err := suite.handler.retry()
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), suite.handler.retryProcess)
+	assert.Equal(suite.T(), int64(630), suite.handler.retryDeferred)
+
+	suite.handler.retryDeferred = 0
+	assert.False(suite.T(), suite.handler.canRetry())
+	assert.True(suite.T(), suite.handler.isExpired(time.Now().Add(30*time.Second)))
+}
+
+func (suite *RetryTestSuite) TestRetry_IsFirstProcessing() {
+	suite.handler.dlv = amqp.Delivery{}
+	assert.True(suite.T(), suite.handler.IsFirstProcessing())
+
+	// the deferred notification keeps the zero retry count
+	suite.handler.dlv = amqp.Delivery{Headers: amqp.Table{
+		retryCountHeader:        int32(0),
+		retryFirstAttemptHeader: time.Now().Unix(),
+	}}
+	assert.False(suite.T(), suite.handler.IsFirstProcessing())
+
+	suite.handler.dlv = amqp.Delivery{}
+	suite.handler.RetryCount = 1
+	assert.False(suite.T(), suite.handler.IsFirstProcessing())
+}
+
+func (suite *RetryTestSuite) TestRetry_getRetryDeferred() {
+	assert.Equal(suite.T(), int64(0), getRetryDeferred(amqp.Delivery{}))
+	assert.Equal(suite.T(), int64(600), getRetryDeferred(amqp.Delivery{Headers: amqp.Table{retryDeferredHeader: int64(600)}}))
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderWebhookId        = "webhook-id"
+	HeaderWebhookTimestamp = "webhook-timestamp"
+	HeaderWebhookSignature = "webhook-signature"
+
+	// Prefix of the secret in the format of the Standard Webhooks libraries
+	standardWebhooksSecretPrefix = "whsec_"
+	standardWebhooksSignatureV1  = "v1"
+)
+
+// StandardWebhooksMessage is the payload envelope of the Standard Webhooks specification
+type StandardWebhooksMessage struct {
+	Type      string                 `json:"type"`
+	Timestamp string                 `json:"timestamp"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// getStandardWebhooksKey returns key of the signature: the decoded secret if it is in the whsec_ format,
+// the secret itself otherwise
+func getStandardWebhooksKey(secretKey string) []byte {
+	if strings.HasPrefix(secretKey, standardWebhooksSecretPrefix) {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secretKey, standardWebhooksSecretPrefix))
+
+		if err == nil {
+			return key
+		}
+	}
+
+	return []byte(secretKey)
+}
+
+// getStandardWebhooksSignature returns base64 encoded HMAC-SHA256 of "id.timestamp.body"
+func getStandardWebhooksSignature(id, timestamp string, req []byte, secretKey string) string {
+	mac := hmac.New(sha256.New, getStandardWebhooksKey(secretKey))
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(req)
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encodeStandardWebhooksMessage returns the message in the Standard Webhooks envelope signed by the specification.
+// Identifier of the message is the event id, so it is the same for all tries of the notification.
+func (n *Default) encodeStandardWebhooksMessage(
+	endpoint *notificationEndpoint,
+	msg *OrderNotificationMessage,
+	now time.Time,
+) ([]byte, map[string]string, error) {
+	b, err := MarshalCanonical(&StandardWebhooksMessage{
+		Type:      msg.Event,
+		Timestamp: msg.CreatedAt,
+		Data:      getNotificationData(msg),
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts := strconv.FormatInt(now.Unix(), 10)
+	headers := map[string]string{
+		HeaderContentType:      MIMEApplicationJSON,
+		HeaderAccept:           MIMEApplicationJSON,
+		HeaderWebhookId:        msg.Id,
+		HeaderWebhookTimestamp: ts,
+		HeaderWebhookSignature: standardWebhooksSignatureV1 + "," +
+			getStandardWebhooksSignature(msg.Id, ts, b, endpoint.secretKey),
+	}
+
+	return b, headers, nil
+}
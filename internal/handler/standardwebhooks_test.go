@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type StandardWebhooksTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_StandardWebhooks(t *testing.T) {
+	suite.Run(t, new(StandardWebhooksTestSuite))
+}
+
+func (suite *StandardWebhooksTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerStandardWebhooks)
+	suite.redis = suite.handler.redis
+}
+
+func (suite *StandardWebhooksTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *StandardWebhooksTestSuite) TestStandardWebhooks_Notify_Ok() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		id := req.Header.Get(HeaderWebhookId)
+		ts := req.Header.Get(HeaderWebhookTimestamp)
+		assert.Len(suite.T(), id, 64)
+		assert.NotEmpty(suite.T(), ts)
+
+		mac := hmac.New(sha256.New, []byte(suite.handler.order.Project.SecretKey))
+		mac.Write([]byte(id + "." + ts + "." + string(b)))
+		signature := "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		assert.Equal(suite.T(), signature, req.Header.Get(HeaderWebhookSignature))
+
+		msg := &StandardWebhooksMessage{}
+		assert.NoError(suite.T(), json.Unmarshal(b, msg))
+		assert.Equal(suite.T(), eventNameSuccess, msg.Type)
+		assert.NotEmpty(suite.T(), msg.Timestamp)
+		assert.Equal(suite.T(), suite.handler.order.Id, msg.Data["id"])
+		return httpmock.NewStringResponse(http.StatusAccepted, ""), nil
+	})
+
+	err := newDefaultHandler(suite.handler).Notify()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *StandardWebhooksTestSuite) TestStandardWebhooks_ThinPayload_Reference() {
+	msg := &OrderNotificationMessage{
+		OrderId:        suite.handler.order.Id,
+		ProjectOrderId: suite.handler.order.ProjectOrderId,
+		Decline:        &OrderNotificationDecline{Code: "ps000001", Reason: "declined"},
+	}
+	data := getNotificationData(msg)
+
+	assert.Equal(suite.T(), suite.handler.order.Id, data["order_id"])
+	assert.Equal(suite.T(), suite.handler.order.ProjectOrderId, data["project_order_id"])
+	assert.NotContains(suite.T(), data, "fetch_url")
+	assert.Equal(suite.T(), msg.Decline, data["decline"])
+}
+
+func (suite *StandardWebhooksTestSuite) TestStandardWebhooks_Secret() {
+	// whsec_ followed by base64 of the secret key of the project
+	secret := "whsec_VW5pdCBUZXN0"
+
+	// signature by the whsec_ secret is the same as by the secret key it encodes
+	s1 := getStandardWebhooksSignature("id", "1577836800", []byte("{}"), suite.handler.order.Project.SecretKey)
+	s2 := getStandardWebhooksSignature("id", "1577836800", []byte("{}"), secret)
+	assert.Equal(suite.T(), s1, s2)
+}
+
+func (suite *StandardWebhooksTestSuite) TestStandardWebhooks_SetResend_Event() {
+	err := suite.handler.SetResend(eventNameRefund)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), eventNameRefund, suite.handler.resendEvent)
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"hash"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	errorTemplateNotConfigured    = "template of the project isn't configured"
+	errorTemplateAlgorithmUnknown = "unknown signature algorithm of the template"
+
+	templateSignaturePayloadDefault = "the raw request body"
+)
+
+var (
+	// Hash algorithms of the template protocol signature
+	templateSignatureHashes = map[string]func() hash.Hash{
+		config.TemplateSignatureMd5:    md5.New,
+		config.TemplateSignatureSha1:   sha1.New,
+		config.TemplateSignatureSha256: sha256.New,
+		config.TemplateSignatureSha512: sha512.New,
+	}
+
+	// Hash algorithms of the template protocol hmac signature
+	templateSignatureHmacHashes = map[string]func() hash.Hash{
+		config.TemplateSignatureHmacSha1:   sha1.New,
+		config.TemplateSignatureHmacSha256: sha256.New,
+		config.TemplateSignatureHmacSha512: sha512.New,
+	}
+
+	// Helper functions of templates of the template protocol
+	templateFuncs = template.FuncMap{
+		"json":    templateJson,
+		"unix":    templateUnix,
+		"default": templateDefault,
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+	}
+)
+
+// ParseTemplates parses templates of the template protocol of all projects with the helper functions of the protocol.
+// Templates must be parsed before the notifications are sent, the service doesn't start with an invalid template.
+func ParseTemplates(cfg *config.Config) error {
+	return cfg.Projects.ParseTemplates(templateFuncs)
+}
+
+// templateData is the value the body and the headers templates of the template protocol are executed over.
+// The order is available only through the notification of the default protocol, so the projection of the
+// order fields applies to the template protocol too.
+type templateData struct {
+	// Notification of the default protocol, {{json .Message}} renders the default protocol payload
+	Message *OrderNotificationMessage
+	Event   string
+	// Identifier of the event, the same for all tries of the notification
+	Id string
+	// Unix time of the request
+	Timestamp int64
+	// Rendered request body, available to the signature and the headers
+	Body string
+	// Signature of the request, available to the headers
+	Signature string
+}
+
+// templateKeyData is the value the signature key template is executed over, the only one with the secret key
+type templateKeyData struct {
+	Event     string
+	Id        string
+	Timestamp int64
+	SecretKey string
+}
+
+// encodeTemplateMessage renders the request of the message by the template of the project. The body is rendered
+// first, then the signature over it and then the headers which can contain the signature.
+func (n *Default) encodeTemplateMessage(
+	endpoint *notificationEndpoint,
+	msg *OrderNotificationMessage,
+	now time.Time,
+) ([]byte, map[string]string, error) {
+	t := n.cfg.GetProject(n.order.GetProject().GetId()).Template
+
+	if t == nil {
+		return nil, nil, errors.New(errorTemplateNotConfigured)
+	}
+
+	data := &templateData{
+		Message:   msg,
+		Event:     msg.Event,
+		Id:        msg.Id,
+		Timestamp: now.Unix(),
+	}
+
+	var err error
+
+	if data.Body, err = t.Execute(config.TemplateNameBody, data); err != nil {
+		return nil, nil, err
+	}
+
+	if t.Signature != nil {
+		if data.Signature, err = getTemplateSignature(t, data, endpoint.secretKey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	headers := map[string]string{HeaderContentType: MIMEApplicationJSON}
+
+	if t.ContentType != "" {
+		headers[HeaderContentType] = t.ContentType
+	}
+
+	for name := range t.Headers {
+		if headers[name], err = t.Execute(config.TemplateNameHeader(name), data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return []byte(data.Body), headers, nil
+}
+
+// getTemplateSignature returns signature of the request by the recipe of the project. The hmac algorithms use
+// the key as the hmac key, the plain hash algorithms sign the payload followed by the key.
+func getTemplateSignature(t *config.Template, data *templateData, secretKey string) (string, error) {
+	var err error
+
+	s := t.Signature
+	payload := data.Body
+
+	if t.HasTemplate(config.TemplateNameSignaturePayload) {
+		if payload, err = t.Execute(config.TemplateNameSignaturePayload, data); err != nil {
+			return "", err
+		}
+	}
+
+	key := secretKey
+
+	if t.HasTemplate(config.TemplateNameSignatureKey) {
+		keyData := &templateKeyData{Event: data.Event, Id: data.Id, Timestamp: data.Timestamp, SecretKey: secretKey}
+
+		if key, err = t.Execute(config.TemplateNameSignatureKey, keyData); err != nil {
+			return "", err
+		}
+	}
+
+	var h hash.Hash
+
+	if fn, ok := templateSignatureHashes[s.Algorithm]; ok {
+		h = fn()
+		payload += key
+	} else if fn, ok := templateSignatureHmacHashes[s.Algorithm]; ok {
+		h = hmac.New(fn, []byte(key))
+	} else {
+		return "", errors.New(errorTemplateAlgorithmUnknown)
+	}
+
+	h.Write([]byte(payload))
+
+	if s.Encoding == config.TemplateSignatureEncodingBase64 {
+		return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getTemplateSignatureHints describes the signature recipe of the template protocol
+func getTemplateSignatureHints(t *config.Template) []string {
+	if t == nil {
+		return []string{errorTemplateNotConfigured}
+	}
+
+	if t.Signature == nil {
+		return []string{"requests aren't signed"}
+	}
+
+	s := t.Signature
+	encoding := s.Encoding
+
+	if encoding == "" {
+		encoding = config.TemplateSignatureEncodingHex
+	}
+
+	payload := templateSignaturePayloadDefault
+
+	if s.Payload != "" {
+		payload = fmt.Sprintf("\"%s\"", s.Payload)
+	}
+
+	key := "the secret key"
+
+	// the key template can contain the key itself, so it's never shown
+	if s.Key != "" {
+		key = "the custom key"
+	}
+
+	hint := fmt.Sprintf("signature is %s encoded %s of %s", encoding, strings.ToUpper(s.Algorithm), payload)
+
+	if _, ok := templateSignatureHmacHashes[s.Algorithm]; ok {
+		hint += " with " + key
+	} else {
+		hint += " followed by " + key
+	}
+
+	hints := []string{hint}
+	names := make([]string, 0, len(t.Headers))
+
+	for name := range t.Headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if text := t.Headers[name]; strings.Contains(text, ".Signature") {
+			hints = append(hints, fmt.Sprintf("%s header is \"%s\"", name, text))
+		}
+	}
+
+	return hints
+}
+
+// templateJson renders the value as canonical json
+func templateJson(v interface{}) (string, error) {
+	b, err := MarshalCanonical(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// templateUnix renders the RFC3339 time of the notification as unix time in seconds, zero if it isn't valid
+func templateUnix(v string) int64 {
+	t, err := time.Parse(time.RFC3339, v)
+
+	if err != nil {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+// templateDefault renders the value or the default one if the value is empty
+func templateDefault(def, v string) string {
+	if v == "" {
+		return def
+	}
+
+	return v
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/jarcoal/httpmock"
+	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type TemplateTestSuite struct {
+	suite.Suite
+	redis   *redis.Client
+	handler *Handler
+}
+
+func Test_Template(t *testing.T) {
+	suite.Run(t, new(TemplateTestSuite))
+}
+
+func (suite *TemplateTestSuite) SetupTest() {
+	suite.handler = newTestHandler(suite, notifierHandlerTemplate)
+	suite.redis = suite.handler.redis
+
+	t := &config.Template{
+		Body:        `{"order":{{json .Message.Object.id}},"status":{{json .Message.Object.status}},"event":{{json .Event}}}`,
+		ContentType: "application/vnd.merchant+json",
+		Headers:     map[string]string{HeaderAuthorization: "Signature {{.Signature}}"},
+		Signature:   &config.TemplateSignature{Algorithm: config.TemplateSignatureSha1},
+	}
+	assert.NoError(suite.T(), t.Parse(suite.handler.order.Project.Id, templateFuncs))
+
+	suite.handler.cfg.Projects = config.Projects{suite.handler.order.Project.Id: {Template: t}}
+}
+
+func (suite *TemplateTestSuite) TearDownTest() {
+	_ = suite.redis.FlushDB()
+	_ = suite.redis.Close()
+}
+
+func (suite *TemplateTestSuite) TestTemplate_Notify_Ok() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("POST", processUrl, func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(suite.T(), "application/vnd.merchant+json", req.Header.Get(HeaderContentType))
+
+		h := sha1.Sum([]byte(string(b) + suite.handler.order.Project.SecretKey))
+		assert.Equal(suite.T(), "Signature "+hex.EncodeToString(h[:]), req.Header.Get(HeaderAuthorization))
+
+		msg := make(map[string]string)
+		assert.NoError(suite.T(), json.Unmarshal(b, &msg))
+		assert.Equal(suite.T(), suite.handler.order.Id, msg["order"])
+		assert.Equal(suite.T(), recurringpb.OrderPublicStatusProcessed, msg["status"])
+		assert.Equal(suite.T(), eventNameSuccess, msg["event"])
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	err := newDefaultHandler(suite.handler).Notify()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int32(recurringpb.OrderStatusProjectComplete), suite.handler.order.PrivateStatus)
+	assert.Equal(suite.T(), 1, httpmock.GetCallCountInfo()["POST "+processUrl])
+}
+
+func (suite *TemplateTestSuite) TestTemplate_getTemplateSignature_Hmac() {
+	data := &templateData{Id: "id", Timestamp: 1577836800, Body: "{}"}
+	t := &config.Template{
+		Body: "{}",
+		Signature: &config.TemplateSignature{
+			Algorithm: config.TemplateSignatureHmacSha256,
+			Payload:   "{{.Timestamp}}.{{.Body}}",
+			Encoding:  config.TemplateSignatureEncodingBase64,
+		},
+	}
+	assert.NoError(suite.T(), t.Parse(suite.handler.order.Project.Id, templateFuncs))
+
+	signature, err := getTemplateSignature(t, data, "Unit Test")
+	assert.NoError(suite.T(), err)
+
+	mac := hmac.New(sha256.New, []byte("Unit Test"))
+	mac.Write([]byte("1577836800.{}"))
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString(mac.Sum(nil)), signature)
+
+	t.Signature.Key = "{{.Id}}:{{.SecretKey}}"
+	assert.NoError(suite.T(), t.Parse(suite.handler.order.Project.Id, templateFuncs))
+
+	signature, err = getTemplateSignature(t, data, "Unit Test")
+	assert.NoError(suite.T(), err)
+
+	mac = hmac.New(sha256.New, []byte("id:Unit Test"))
+	mac.Write([]byte("1577836800.{}"))
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString(mac.Sum(nil)), signature)
+}
+
+func (suite *TemplateTestSuite) TestTemplate_SecretKey_OnlyInKey() {
+	n := &Default{Handler: suite.handler}
+	endpoint := newNotificationEndpoint(processUrl, suite.handler.order.Project.SecretKey)
+	msg := &OrderNotificationMessage{Id: "id", Event: eventNameSuccess}
+	t := suite.handler.cfg.Projects[suite.handler.order.Project.Id].Template
+
+	for _, body := range []string{"{{.SecretKey}}", "{{.Order.Id}}"} {
+		t.Body = body
+		assert.NoError(suite.T(), t.Parse(suite.handler.order.Project.Id, templateFuncs))
+
+		_, _, err := n.encodeTemplateMessage(endpoint, msg, time.Now())
+		assert.Error(suite.T(), err)
+	}
+
+	t.Body = "{}"
+	t.Signature.Payload = "{{.Body}}{{.SecretKey}}"
+	assert.NoError(suite.T(), t.Parse(suite.handler.order.Project.Id, templateFuncs))
+
+	_, _, err := n.encodeTemplateMessage(endpoint, msg, time.Now())
+	assert.Error(suite.T(), err)
+}
+
+func (suite *TemplateTestSuite) TestTemplate_Decode_Parsed() {
+	projects := config.Projects{}
+	err := projects.Decode(`{"p1": {"template": {"body": "{{json .Event}}", "headers": {"X-Id": "{{upper .Id}}"}}}}`)
+	assert.NoError(suite.T(), err)
+
+	// the settings are decoded without the helper functions, templates are parsed explicitly
+	_, err = projects["p1"].Template.Execute(config.TemplateNameBody, &templateData{})
+	assert.Error(suite.T(), err)
+	assert.NoError(suite.T(), ParseTemplates(&config.Config{Projects: projects}))
+
+	data := &templateData{Event: eventNameSuccess, Id: "id"}
+	body, err := projects["p1"].Template.Execute(config.TemplateNameBody, data)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), `"payment.success"`, body)
+
+	header, err := projects["p1"].Template.Execute(config.TemplateNameHeader("X-Id"), data)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "ID", header)
+
+	invalid := []string{
+		`{"p1": {"template": {"body": "{{json .Event"}}}`,
+		`{"p1": {"template": {"body": "{{unknown .Event}}"}}}`,
+	}
+
+	for _, v := range invalid {
+		projects = config.Projects{}
+		assert.NoError(suite.T(), projects.Decode(v))
+		assert.Error(suite.T(), ParseTemplates(&config.Config{Projects: projects}))
+	}
+}
+
+func (suite *TemplateTestSuite) TestTemplate_encodeTemplateMessage_Error() {
+	n := &Default{Handler: suite.handler}
+	endpoint := newNotificationEndpoint(processUrl, suite.handler.order.Project.SecretKey)
+	msg := &OrderNotificationMessage{Id: "id", Event: eventNameSuccess}
+
+	// the template changed after the settings were decoded isn't parsed again
+	suite.handler.cfg.Projects[suite.handler.order.Project.Id].Template = &config.Template{Body: "{}"}
+	_, _, err := n.encodeTemplateMessage(endpoint, msg, time.Now())
+	assert.Error(suite.T(), err)
+
+	suite.handler.cfg.Projects = nil
+	_, _, err = n.encodeTemplateMessage(endpoint, msg, time.Now())
+	assert.EqualError(suite.T(), err, errorTemplateNotConfigured)
+}
+
+func (suite *TemplateTestSuite) TestTemplate_getSignatureHints() {
+	hints := suite.handler.getSignatureHints()
+	assert.Len(suite.T(), hints, 2)
+	assert.Contains(suite.T(), hints[0], "SHA1")
+	assert.Contains(suite.T(), hints[0], "followed by the secret key")
+	assert.Contains(suite.T(), hints[1], HeaderAuthorization)
+
+	t := suite.handler.cfg.Projects[suite.handler.order.Project.Id].Template
+	t.Signature = &config.TemplateSignature{Algorithm: config.TemplateSignatureHmacSha256, Key: "{{.SecretKey}}-key"}
+
+	hints = suite.handler.getSignatureHints()
+	assert.Contains(suite.T(), hints[0], "with the custom key")
+	assert.NotContains(suite.T(), hints[0], t.Signature.Key)
+}
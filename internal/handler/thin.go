@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PayloadFetchPathMask     = "/orders/%s/payload"
+	PayloadFetchQueryExpires = "expires"
+	PayloadFetchQueryToken   = "token"
+	// Length of the hex encoded fetch token
+	PayloadFetchTokenLength = sha256.Size * 2
+)
+
+// GetPayloadFetchToken returns token to fetch payload of the order until expiration time in unix seconds.
+// The token is the hex encoded HMAC-SHA256 of "order_id.expires" with the project secret key.
+func GetPayloadFetchToken(secret, orderId string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(orderId + "." + strconv.FormatInt(expires, 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckPayloadFetchToken checks that the token to fetch payload of the order is valid and not expired
+func CheckPayloadFetchToken(secret, orderId string, expires int64, token string, now time.Time) bool {
+	if secret == "" || expires < now.Unix() {
+		return false
+	}
+
+	expected := GetPayloadFetchToken(secret, orderId, expires)
+
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(token)))
+}
+
+// getPayloadMode returns payload mode of the default protocol for the order project
+func (h *Handler) getPayloadMode() string {
+	if mode := h.cfg.GetProject(h.order.GetProject().GetId()).PayloadMode; mode != "" {
+		return mode
+	}
+
+	return config.PayloadModeFull
+}
+
+// getPayloadFetchUrl returns signed url to fetch payload of the order and its expiration time
+func (h *Handler) getPayloadFetchUrl(now time.Time) (string, string) {
+	expiresAt := now.Add(time.Duration(h.cfg.PayloadFetchTtl) * time.Second)
+	expires := expiresAt.Unix()
+	orderId := h.order.GetId()
+
+	query := url.Values{}
+	query.Set(PayloadFetchQueryExpires, strconv.FormatInt(expires, 10))
+	query.Set(PayloadFetchQueryToken, GetPayloadFetchToken(h.order.GetProject().GetSecretKey(), orderId, expires))
+
+	u := strings.TrimRight(h.cfg.PayloadFetchUrl, "/") + fmt.Sprintf(PayloadFetchPathMask, url.PathEscape(orderId))
+
+	return u + "?" + query.Encode(), FormatTimestamp(expiresAt)
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
@@ -9,6 +8,7 @@ import (
 	"fmt"
 	"github.com/micro/protobuf/ptypes"
 	"github.com/paysuper/paysuper-proto/go/recurringpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/config"
 	"net/http"
 )
 
@@ -44,14 +44,14 @@ func (n *XSolla) Notify() error {
 		return n.handleErrorWithRetry(loggerErrorNotificationRetry, err, nil)
 	}
 
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+	if n.classifyResponse(resp) == config.ResponseResultSuccess {
 		n.order.PrivateStatus = recurringpb.OrderStatusProjectComplete
 	} else {
 		// in future in that case must be generating refund request to payment system
 		n.order.PrivateStatus = recurringpb.OrderStatusProjectReject
 	}
 
-	if _, err := n.repository.UpdateOrder(context.TODO(), n.order); err != nil {
+	if err := n.updateOrder(n.order); err != nil {
 		n.HandleError(loggerErrorNotificationUpdate, err, nil)
 	}
 
@@ -83,11 +83,8 @@ func (n *XSolla) sendRequest(url string, req interface{}, action string) (*http.
 		return nil, err
 	}
 
-	oId := n.order.GetId()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent &&
-		resp.StatusCode != http.StatusUnprocessableEntity {
-		return nil, errors.New(fmt.Sprintf(errorNotificationNeedRetry, oId, action))
+	if n.classifyResponse(resp) == config.ResponseResultRetry {
+		return nil, errors.New(fmt.Sprintf(errorNotificationNeedRetry, n.order.GetId(), action))
 	}
 
 	return resp, nil
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+const (
+	namespace = "notifier"
+
+	ResultSuccess = "success"
+	ResultFail    = "fail"
+
+	// Outcomes of the notification processing
+	ResultDelivered = "delivered"
+	ResultRetried   = "retried"
+	ResultDeferred  = "deferred"
+	ResultParked    = "parked"
+	ResultRejected  = "rejected"
+	ResultExpired   = "expired"
+	ResultSkipped   = "skipped"
+
+	StatusClassError = "error"
+)
+
+var (
+	// Processed notifications by callback protocol and result of the processing
+	NotificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "notifications_total",
+			Help:      "Count of processed notifications by callback protocol and outcome of the processing",
+		},
+		[]string{"protocol", "result"},
+	)
+
+	// Retry count of processed notifications
+	NotificationRetryCount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "notification_retry_count",
+			Help:      "Retry count of processed notifications by callback protocol",
+			Buckets:   []float64{0, 1, 2, 3, 5, 10, 20, 50, 100, 200, 288},
+		},
+		[]string{"protocol"},
+	)
+
+	// Http requests to projects by callback protocol and http status class of the response
+	DeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deliveries_total",
+			Help:      "Count of http requests to projects by callback protocol and response status class",
+		},
+		[]string{"protocol", "status_class"},
+	)
+
+	// Duration of http requests to projects
+	DeliveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delivery_duration_seconds",
+			Help:      "Duration of http requests to projects by callback protocol",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"protocol"},
+	)
+
+	// Notifications republished to the retry queues
+	RetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Count of notifications republished to the retry queues by callback protocol",
+		},
+		[]string{"protocol"},
+	)
+
+	// Notifications exceeded the retry limits
+	RetriesExhaustedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_exhausted_total",
+			Help:      "Count of notifications exceeded the retry limits by callback protocol",
+		},
+		[]string{"protocol"},
+	)
+
+	// Notifications skipped because the order is processing by another consumer
+	LockContentionTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lock_contention_total",
+			Help:      "Count of notifications skipped because the order lock is held by another consumer",
+		},
+	)
+
+	// Errors of the order lock obtaining
+	LockErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lock_errors_total",
+			Help:      "Count of errors of the order lock obtaining",
+		},
+	)
+
+	// Openings of the circuit breakers of project endpoints
+	BreakerOpenedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "breaker_opened_total",
+			Help:      "Count of openings of the circuit breakers of project endpoints by callback protocol",
+		},
+		[]string{"protocol"},
+	)
+
+	// Deliveries deferred to the retry queues without http request because the circuit breaker is open
+	BreakerDeferredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "breaker_deferred_total",
+			Help:      "Count of deliveries deferred because the circuit breaker of project endpoint is open by callback protocol",
+		},
+		[]string{"protocol"},
+	)
+
+	// Deliveries delayed to the retry queues without http request because of the outbound rate limits
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Count of deliveries delayed by the outbound rate limits by callback protocol and limit scope",
+		},
+		[]string{"protocol", "scope"},
+	)
+
+	// Notifications dropped because the event lifetime passed
+	NotificationsExpiredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "notifications_expired_total",
+			Help:      "Count of notifications dropped after the event lifetime by callback protocol",
+		},
+		[]string{"protocol"},
+	)
+
+	// Failed publications of messages to centrifugo
+	CentrifugoPublishFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "centrifugo_publish_failures_total",
+			Help:      "Count of failed publications of messages to centrifugo",
+		},
+	)
+
+	// Publications of orders to TaxJar topics by notification type and result
+	TaxjarPublishesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "taxjar_publishes_total",
+			Help:      "Count of orders publications to TaxJar topics by notification type and result",
+		},
+		[]string{"type", "result"},
+	)
+
+	// Errors of the order update in billing server
+	UpdateOrderErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "update_order_errors_total",
+			Help:      "Count of errors of the order update in billing server",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		NotificationsTotal,
+		NotificationRetryCount,
+		DeliveriesTotal,
+		DeliveryDuration,
+		RetriesTotal,
+		RetriesExhaustedTotal,
+		LockContentionTotal,
+		LockErrorsTotal,
+		BreakerOpenedTotal,
+		BreakerDeferredTotal,
+		RateLimitedTotal,
+		NotificationsExpiredTotal,
+		CentrifugoPublishFailuresTotal,
+		TaxjarPublishesTotal,
+		UpdateOrderErrorsTotal,
+	)
+}
+
+// GetStatusClass returns class of http status code, for example "2xx" for 200
+func GetStatusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// GetResult returns result label value by the error
+func GetResult(err error) string {
+	if err != nil {
+		return ResultFail
+	}
+
+	return ResultSuccess
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestGetStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", GetStatusClass(http.StatusOK))
+	assert.Equal(t, "2xx", GetStatusClass(http.StatusNoContent))
+	assert.Equal(t, "4xx", GetStatusClass(http.StatusUnprocessableEntity))
+	assert.Equal(t, "5xx", GetStatusClass(http.StatusServiceUnavailable))
+}
+
+func TestGetResult(t *testing.T) {
+	assert.Equal(t, ResultSuccess, GetResult(nil))
+	assert.Equal(t, ResultFail, GetResult(errors.New("some error")))
+}
@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/streadway/amqp"
+)
+
+type ParkingMockOk struct {
+	Parked  []*billingpb.Order
+	Headers []amqp.Table
+}
+
+func NewParkingMockOk() *ParkingMockOk {
+	return &ParkingMockOk{}
+}
+
+func (p *ParkingMockOk) Publish(order *billingpb.Order, h amqp.Table) error {
+	p.Parked = append(p.Parked, order)
+	p.Headers = append(p.Headers, h)
+	return nil
+}
+
+func (p *ParkingMockOk) Replay(ids []string, limit int, fn func(*billingpb.Order) error) (int, error) {
+	var (
+		parked   []*billingpb.Order
+		headers  []amqp.Table
+		err      error
+		replayed int
+	)
+
+	selected := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	for i, order := range p.Parked {
+		if err != nil || (limit > 0 && replayed >= limit) || (len(selected) > 0 && !selected[order.GetId()]) {
+			parked = append(parked, order)
+			headers = append(headers, p.Headers[i])
+			continue
+		}
+
+		if err = fn(order); err != nil {
+			parked = append(parked, order)
+			headers = append(headers, p.Headers[i])
+			continue
+		}
+
+		replayed++
+	}
+
+	p.Parked = parked
+	p.Headers = headers
+
+	return replayed, err
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+const (
+	errorTestProjectIdEmpty  = "project identifier is empty"
+	errorTestProjectNotFound = "project not found"
+)
+
+// TestWebhook sends the test notification to the project endpoints synchronously with the project callback protocol
+// and the secret key and returns the requests, the responses and the signature verification hints. Event is
+// webhook.ping or the event of the sample order, payment.success by default. Nothing is stored by the test.
+func (app *NotifierApplication) TestWebhook(ctx context.Context, projectId, event string) (*handler.TestResult, error) {
+	if projectId == "" {
+		return nil, errors.New(errorTestProjectIdEmpty)
+	}
+
+	rsp, err := app.repo.GetProject(ctx, &billingpb.GetProjectRequest{ProjectId: projectId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Status != billingpb.ResponseStatusOk || rsp.Item == nil {
+		app.log.Error(errorTestProjectNotFound, zap.String("project_id", projectId), zap.Any("message", rsp.Message))
+		return nil, errors.New(errorTestProjectNotFound)
+	}
+
+	p := rsp.Item
+	o := handler.NewSampleOrder(&billingpb.ProjectOrder{
+		Id:                   p.Id,
+		MerchantId:           p.MerchantId,
+		Name:                 p.Name,
+		SecretKey:            p.SecretKey,
+		UrlCheckAccount:      p.UrlCheckAccount,
+		UrlProcessPayment:    p.UrlProcessPayment,
+		UrlChargebackPayment: p.UrlChargebackPayment,
+		UrlCancelPayment:     p.UrlCancelPayment,
+		UrlRefundPayment:     p.UrlRefundPayment,
+		CallbackProtocol:     p.CallbackProtocol,
+		Status:               p.Status,
+	})
+
+	h := app.newHandler(o, amqp.Delivery{})
+
+	if err = h.SetTest(event); err != nil {
+		return nil, err
+	}
+
+	n, err := h.GetNotifier()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := h.GetTestResult(n.Notify())
+	app.log.Info(
+		"Test notification sent",
+		zap.String("project_id", projectId),
+		zap.String("event", result.Event),
+		zap.Bool("delivered", result.Delivered),
+		zap.String("error", result.Error),
+	)
+
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bsm/redis-lock"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/internal/handler"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+const (
+	errorResendOrderIdEmpty    = "order identifier is empty"
+	errorResendOrderNotFound   = "order not found"
+	errorResendOrderProcessing = "notification for the order is processing now"
+)
+
+// Resend sends notification for the order synchronously regardless it was sent before and returns delivery result.
+// Event overrides the event of the current order status for the default callback protocol.
+func (app *NotifierApplication) Resend(ctx context.Context, orderId, event string) (*handler.ResendResult, error) {
+	if orderId == "" {
+		return nil, errors.New(errorResendOrderIdEmpty)
+	}
+
+	rsp, err := app.repo.GetOrderPrivate(ctx, &billingpb.GetOrderRequest{OrderId: orderId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.Status != billingpb.ResponseStatusOk || rsp.Item == nil {
+		app.log.Error(errorResendOrderNotFound, zap.String("order_id", orderId), zap.Any("message", rsp.Message))
+		return nil, errors.New(errorResendOrderNotFound)
+	}
+
+	o := rsp.Item
+	mName := fmt.Sprintf(mutexNameMask, o.Project.GetCallbackProtocol(), o.Id)
+	mutex, err := lock.Obtain(app.redis, mName, nil)
+
+	if err != nil {
+		return nil, err
+	} else if mutex == nil {
+		return nil, errors.New(errorResendOrderProcessing)
+	}
+
+	defer func() {
+		if err := mutex.Unlock(); err != nil {
+			app.log.Error("Mutex unlock failed", zap.Error(err))
+		}
+	}()
+
+	h := app.newHandler(o, amqp.Delivery{})
+
+	if err = h.SetResend(event); err != nil {
+		return nil, err
+	}
+
+	n, err := h.GetNotifier()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := h.GetResendResult(n.Notify())
+	app.log.Info("Notification resent", zap.Any("result", result))
+
+	return result, nil
+}
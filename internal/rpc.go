@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"context"
+	"github.com/paysuper/paysuper-proto/go/billingpb"
+	"github.com/paysuper/paysuper-webhook-notifier/pkg/notifierpb"
+)
+
+// NotifierService is the micro service handler of the notifier
+type NotifierService struct {
+	app *NotifierApplication
+}
+
+// Resend sends notification for the order synchronously and returns delivery result
+func (s *NotifierService) Resend(ctx context.Context, req *notifierpb.ResendRequest, rsp *notifierpb.ResendResponse) error {
+	result, err := s.app.Resend(ctx, req.OrderId, req.Event)
+
+	if err != nil {
+		rsp.Status = billingpb.ResponseStatusBadData
+		rsp.Message = err.Error()
+		return nil
+	}
+
+	rsp.Status = billingpb.ResponseStatusOk
+	rsp.Delivered = result.Delivered
+	rsp.Event = result.Event
+	rsp.Error = result.Error
+
+	if result.Attempt != nil {
+		rsp.ResponseStatus = int32(result.Attempt.ResponseStatus)
+		rsp.LatencyMs = result.Attempt.LatencyMs
+	}
+
+	for _, e := range result.Endpoints {
+		item := &notifierpb.EndpointResult{Url: e.Url, Delivered: e.Delivered, Error: e.Error}
+
+		if e.Attempt != nil {
+			item.ResponseStatus = int32(e.Attempt.ResponseStatus)
+			item.LatencyMs = e.Attempt.LatencyMs
+		}
+
+		rsp.Endpoints = append(rsp.Endpoints, item)
+	}
+
+	return nil
+}